@@ -0,0 +1,161 @@
+// Fixture repos shared by utils and modules tests. Kept apart from utils,
+// so test helpers don't end up in the app and utils tests can use them without import cycles
+package testutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	TEST_BUNDLE_HEADER      = "# v2 git bundle"
+	TEST_REPO_BRANCH        = "dev"
+	TEST_REPO_TAG           = "1.0.0"
+	TEST_REPO_ANNOTATED_TAG = "1.1.0"
+)
+
+var testSignature = &object.Signature{
+	Name:  "Tester",
+	Email: "tester@example.com",
+	When:  time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// Local fixture repo, so clone tests don't depend on network access
+type TestRepo struct {
+	Repo        *git.Repository
+	Dir         string
+	Url         string
+	FirstCommit plumbing.Hash
+	MainCommit  plumbing.Hash
+	DevCommit   plumbing.Hash
+}
+
+// Creates fixture repo with main and dev branches,
+// lightweight tag on the first commit and annotated tag on main
+func CreateTestRepo(t *testing.T, repoDir string) TestRepo {
+	t.Helper()
+
+	repoDir, err := filepath.Abs(repoDir)
+	checkError(t, err)
+
+	repo := InitTestRepo(t, repoDir)
+	workTree, err := repo.Worktree()
+	checkError(t, err)
+
+	testRepo := TestRepo{
+		Repo: repo,
+		Dir:  repoDir,
+		Url:  "file://" + filepath.ToSlash(repoDir),
+	}
+
+	testRepo.FirstCommit = CommitTestFile(t, repo, "index.js", "module.exports = {};\n")
+	_, err = repo.CreateTag(TEST_REPO_TAG, testRepo.FirstCommit, nil)
+	checkError(t, err)
+
+	testRepo.MainCommit = CommitTestFile(t, repo, "readme.md", "# Test module\n")
+	_, err = repo.CreateTag(TEST_REPO_ANNOTATED_TAG, testRepo.MainCommit, &git.CreateTagOptions{
+		Tagger:  testSignature,
+		Message: "Release " + TEST_REPO_ANNOTATED_TAG,
+	})
+	checkError(t, err)
+
+	err = workTree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(TEST_REPO_BRANCH),
+		Create: true,
+	})
+	checkError(t, err)
+
+	testRepo.DevCommit = CommitTestFile(t, repo, "dev.js", "module.exports = { dev: true };\n")
+
+	err = workTree.Checkout(&git.CheckoutOptions{Branch: plumbing.Main})
+	checkError(t, err)
+
+	return testRepo
+}
+
+func InitTestRepo(t *testing.T, repoDir string) *git.Repository {
+	t.Helper()
+
+	repo, err := git.PlainInitWithOptions(repoDir, &git.PlainInitOptions{
+		InitOptions: git.InitOptions{DefaultBranch: plumbing.Main},
+	})
+	checkError(t, err)
+
+	return repo
+}
+
+func CommitTestFile(t *testing.T, repo *git.Repository, fileName string, content string) plumbing.Hash {
+	t.Helper()
+
+	workTree, err := repo.Worktree()
+	checkError(t, err)
+
+	err = os.WriteFile(filepath.Join(workTree.Filesystem.Root(), fileName), []byte(content), 0o644)
+	checkError(t, err)
+
+	_, err = workTree.Add(fileName)
+	checkError(t, err)
+
+	hash, err := workTree.Commit("Add "+fileName, &git.CommitOptions{Author: testSignature})
+	checkError(t, err)
+
+	return hash
+}
+
+// Writes all repo branches and tags into a git bundle file (like git bundle create --all)
+func CreateTestBundle(t *testing.T, repo *git.Repository, bundlePath string) {
+	t.Helper()
+
+	bundle, err := os.Create(bundlePath)
+	checkError(t, err)
+	defer bundle.Close()
+
+	head, err := repo.Head()
+	checkError(t, err)
+
+	_, err = fmt.Fprintf(bundle, "%s\n%s %s\n", TEST_BUNDLE_HEADER, head.Hash(), plumbing.HEAD)
+	checkError(t, err)
+
+	refs, err := repo.References()
+	checkError(t, err)
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			return nil
+		}
+
+		_, err := fmt.Fprintf(bundle, "%s %s\n", ref.Hash(), ref.Name())
+		return err
+	})
+	checkError(t, err)
+
+	_, err = fmt.Fprintln(bundle)
+	checkError(t, err)
+
+	objects, err := repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	checkError(t, err)
+
+	hashes := []plumbing.Hash{}
+	err = objects.ForEach(func(object plumbing.EncodedObject) error {
+		hashes = append(hashes, object.Hash())
+		return nil
+	})
+	checkError(t, err)
+
+	_, err = packfile.NewEncoder(bundle, repo.Storer, false).Encode(hashes, 10)
+	checkError(t, err)
+}
+
+func checkError(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"flag"
-	"maps"
+	"os"
 
 	"easymodules/modules"
 	"easymodules/utils"
@@ -12,22 +12,46 @@ import (
 
 func main() {
 	log.SetLevel(log.DebugLevel)
+	utils.SetRedactingLogOutput()
 
 	utils.InitEnv()
 
 	showChangedModules := flag.Bool("show-changed-modules", false, "run command to show modules with unsaved git changes")
 	parallelInstall := flag.Bool("parallel-install", true, "install modules in parallel (true/false)")
+	validateConfig := flag.Bool("validate", false, "run command to check config for invalid git urls, references, duplicate and unsafe module names")
+	validateRemote := flag.Bool("validate-remote", false, "together with -validate, also check that every reference exists on the remote")
+	production := flag.Bool("production", utils.GetEnvBool(utils.ENV_PRODUCTION), "install (or show changed) only dependencies, without devDependencies. Same as PRODUCTION=true env variable")
+	prune := flag.Bool("prune", false, "together with -production, delete installed devDependencies modules that have no unsaved changes")
+	groups := flag.String("group", "", "install (or show changed) only modules from comma separated groups, e.g. ui,checkout. Modules without groups are in \"default\" group, prefix group with ! to exclude it")
 	safeInstall := flag.Bool("safe-install", true, "if this is set to false, modules folder will be deleted on start. Default version - each module is checked separately, and only if module has no unsaved changes, it's deleted and then reinstalled")
 	flag.Parse()
 
 	if *showChangedModules {
-		modules.ShowChangedModules()
+		configJson := modules.ReadConfigJson()
+
+		var statusModules map[string]string
+		if *production || *groups != "" {
+			statusModules = getDependencies(configJson, *production)
+			statusModules = modules.SelectModuleGroups(statusModules, configJson.Modules, *groups)
+		}
+
+		modules.ShowChangedModules(statusModules, configJson.Modules)
+		return
+	}
+
+	if *validateConfig {
+		if !modules.ValidateConfig(*validateRemote) {
+			os.Exit(1)
+		}
 		return
 	}
 
 	configJson := modules.ReadConfigJson()
-	dependencies := configJson.Dependencies
-	maps.Copy(dependencies, configJson.DevDependencies)
+	dependencies := getDependencies(configJson, *production)
+
+	if *production && *prune {
+		modules.PruneModules(configJson.GetDevOnlyDependencies(), configJson.Modules)
+	}
 
 	gitDependencies := map[string]string{}
 	for key, value := range dependencies {
@@ -41,10 +65,28 @@ func main() {
 		return
 	}
 
-	if !*safeInstall {
+	// All modules are checked, so a selected module can't delete folder of a module that is not selected
+	modules.CheckModuleDirs(gitDependencies, configJson.Modules)
+
+	isSelectedInstall := len(flag.Args()) > 0 || *groups != ""
+	gitDependencies = modules.SelectModules(gitDependencies, flag.Args())
+	gitDependencies = modules.SelectModuleGroups(gitDependencies, configJson.Modules, *groups)
+
+	if !*safeInstall && isSelectedInstall {
+		modules.RemoveModules(gitDependencies, configJson.Modules)
+	} else if !*safeInstall {
 		modules.RemoveModulesDir()
 	}
 
 	modules.CreateModulesDir()
-	modules.InstallModules(gitDependencies, *parallelInstall)
+	modules.InstallModules(gitDependencies, configJson.Modules, *parallelInstall)
+}
+
+func getDependencies(configJson modules.JsonConfig, production bool) map[string]string {
+	if !production {
+		return configJson.GetAllDependencies()
+	}
+
+	log.Info(utils.PrepareWarningOutput("Production mode: devDependencies are skipped"))
+	return configJson.GetProductionDependencies()
 }
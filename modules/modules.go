@@ -3,9 +3,12 @@ package modules
 import (
 	"easymodules/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -16,23 +19,188 @@ import (
 type JsonConfig struct {
 	Dependencies    map[string]string
 	DevDependencies map[string]string
+	Modules         map[string]ModuleConfig `json:"easyModules"`
+}
+
+// Extra per-module settings, kept outside of dependencies so package.json stays valid for npm
+type ModuleConfig struct {
+	Mirrors []string
+	Groups  []string
+	// Install folder relative to project root, overrides MODULES_DIR/<name>
+	Path string
+	// Ssh key for this module, overrides SSH_HOST_KEYS, ssh config and SSH_KEY_PATH.
+	// Password should be a ${VAR} placeholder, so it's kept in go.env.local or env
+	SshKey         string
+	SshKeyPassword string
+}
+
+func (moduleConfig ModuleConfig) GetSshIdentity() utils.SshIdentity {
+	return utils.SshIdentity{KeyPath: moduleConfig.SshKey, KeyPassword: moduleConfig.SshKeyPassword}
 }
 
 var MODULES_DIR_PERMISSIONS os.FileMode = 0o777
 
+// npm-style scope, @company/ui-kit is installed into MODULES_DIR/@company/ui-kit
+const MODULE_SCOPE_PREFIX = "@"
+
 func getModulesDir() string {
-	return utils.GetEnv(utils.ENV_MODULES_DIR)
+	return utils.GetEnvPath(utils.ENV_MODULES_DIR)
 }
 
 func ReadConfigJson() JsonConfig {
-	configJson, fileErr := os.ReadFile(utils.GetEnv(utils.ENV_CONFIG_FILE))
-	utils.CheckError(fileErr, "Error when opening json configuration file")
+	configJson, err := readConfigJson()
+	utils.CheckError(err, "Error when reading json configuration file")
+
+	warnDependencyCollisions(configJson)
 
+	return configJson
+}
+
+// Reads and expands config. Expansion errors of all modules are joined, config with them is still returned
+func readConfigJson() (JsonConfig, error) {
 	var configJsonParsed JsonConfig
-	jsonErr := json.Unmarshal(configJson, &configJsonParsed)
-	utils.CheckError(jsonErr, "Error when parsing json configuration file")
 
-	return configJsonParsed
+	configFile, err := utils.ExpandPath(utils.GetEnv(utils.ENV_CONFIG_FILE))
+	if err != nil {
+		return configJsonParsed, fmt.Errorf("error when expanding CONFIG_FILE: %w", err)
+	}
+
+	configJson, err := os.ReadFile(configFile)
+	if err != nil {
+		return configJsonParsed, fmt.Errorf("error when opening json configuration file: %w", err)
+	}
+
+	err = json.Unmarshal(configJson, &configJsonParsed)
+	if err != nil {
+		return configJsonParsed, fmt.Errorf("error when parsing json configuration file %s: %w", configFile, err)
+	}
+
+	expandErrors := []error{}
+	expandErrors = append(expandErrors, expandDependencies(configJsonParsed.Dependencies)...)
+	expandErrors = append(expandErrors, expandDependencies(configJsonParsed.DevDependencies)...)
+	expandErrors = append(expandErrors, expandModuleConfigs(configJsonParsed.Modules)...)
+	slices.SortFunc(expandErrors, func(a error, b error) int { return strings.Compare(a.Error(), b.Error()) })
+
+	return configJsonParsed, errors.Join(expandErrors...)
+}
+
+// Merges devDependencies into dependencies. If module is declared in both with different urls,
+// dependencies entry wins - so dev installs get the same module version as production ones
+func (configJson JsonConfig) GetAllDependencies() map[string]string {
+	allDependencies := map[string]string{}
+	maps.Copy(allDependencies, configJson.DevDependencies)
+	maps.Copy(allDependencies, configJson.Dependencies)
+
+	return allDependencies
+}
+
+// Only dependencies, for production installs
+func (configJson JsonConfig) GetProductionDependencies() map[string]string {
+	productionDependencies := map[string]string{}
+	maps.Copy(productionDependencies, configJson.Dependencies)
+
+	return productionDependencies
+}
+
+// devDependencies that are not overridden by dependencies
+func (configJson JsonConfig) GetDevOnlyDependencies() map[string]string {
+	devOnlyDependencies := map[string]string{}
+
+	for name, url := range configJson.DevDependencies {
+		if _, ok := configJson.Dependencies[name]; !ok {
+			devOnlyDependencies[name] = url
+		}
+	}
+
+	return devOnlyDependencies
+}
+
+// Returns sorted names of modules declared in both dependencies and devDependencies with different urls
+func findDependencyCollisions(configJson JsonConfig) []string {
+	collisions := []string{}
+
+	for name, devUrl := range configJson.DevDependencies {
+		url, ok := configJson.Dependencies[name]
+		if ok && url != devUrl {
+			collisions = append(collisions, name)
+		}
+	}
+
+	slices.Sort(collisions)
+	return collisions
+}
+
+func warnDependencyCollisions(configJson JsonConfig) {
+	for _, name := range findDependencyCollisions(configJson) {
+		log.Warnf(
+			utils.PrepareDangerOutput(
+				"Module \"%s\" is declared twice with different urls:\n"+
+					"  dependencies:    %s\n"+
+					"  devDependencies: %s\n"+
+					"Using the one from dependencies",
+			),
+			name,
+			utils.RedactSecrets(configJson.Dependencies[name]),
+			utils.RedactSecrets(configJson.DevDependencies[name]),
+		)
+	}
+}
+
+// Expands ${VAR} placeholders in dependency urls and refs, so tokens don't have to be committed
+func expandDependencies(dependencies map[string]string) []error {
+	expandErrors := []error{}
+
+	for name, url := range dependencies {
+		expandedUrl, err := utils.ExpandGitUrlPlaceholders(url)
+		if err != nil {
+			expandErrors = append(expandErrors, fmt.Errorf("module %s: %w", name, err))
+			continue
+		}
+
+		dependencies[name] = expandedUrl
+	}
+
+	return expandErrors
+}
+
+func expandModuleConfigs(moduleConfigs map[string]ModuleConfig) []error {
+	expandErrors := []error{}
+
+	for name, moduleConfig := range moduleConfigs {
+		for i, mirror := range moduleConfig.Mirrors {
+			expandedMirror, err := utils.ExpandGitUrlPlaceholders(mirror)
+			if err != nil {
+				expandErrors = append(expandErrors, fmt.Errorf("module %s mirror: %w", name, err))
+				continue
+			}
+
+			moduleConfig.Mirrors[i] = expandedMirror
+		}
+
+		// Literal password is still a secret, even though it's better kept out of package.json
+		utils.AddSecret(moduleConfig.SshKeyPassword)
+
+		expandedPassword, err := utils.ExpandEnvPlaceholders(moduleConfig.SshKeyPassword)
+		if err != nil {
+			expandErrors = append(expandErrors, fmt.Errorf("module %s sshKeyPassword: %w", name, err))
+		}
+		utils.AddSecret(expandedPassword)
+
+		expandedKey, err := utils.ExpandEnvPlaceholders(moduleConfig.SshKey)
+		if err == nil && expandedKey != "" {
+			expandedKey, err = utils.ExpandPath(expandedKey)
+		}
+
+		if err != nil {
+			expandErrors = append(expandErrors, fmt.Errorf("module %s sshKey: %w", name, err))
+		}
+
+		moduleConfig.SshKeyPassword = expandedPassword
+		moduleConfig.SshKey = expandedKey
+		moduleConfigs[name] = moduleConfig
+	}
+
+	return expandErrors
 }
 
 func CreateModulesDir() {
@@ -46,15 +214,36 @@ func RemoveModulesDir() {
 	log.Debug(utils.PrepareDangerOutput("Modules folder deleted before installation"))
 }
 
-func InstallModules(modules map[string]string, parallelInstall bool) {
+// Deletes folders of given modules, even if they have unsaved changes
+func RemoveModules(modules map[string]string, moduleConfigs map[string]ModuleConfig) {
+	CheckModuleDirs(modules, moduleConfigs)
+
+	for moduleName := range modules {
+		err := os.RemoveAll(getModuleDir(moduleName, moduleConfigs[moduleName]))
+		utils.CheckError(err, "Error while trying to delete module folder for "+moduleName)
+
+		removeEmptyScopeDir(moduleName, moduleConfigs[moduleName])
+	}
+
+	log.Debug(utils.PrepareDangerOutput("Selected modules folders deleted before installation"))
+}
+
+func InstallModules(
+	modules map[string]string,
+	moduleConfigs map[string]ModuleConfig,
+	parallelInstall bool,
+) {
+	CheckModuleDirs(modules, moduleConfigs)
+
 	log.Debugf("Installing modules into %s", getModulesDir())
 	fmt.Println()
 
 	start := time.Now()
+	summary := installSummary{}
 
 	if !parallelInstall {
 		for name, url := range modules {
-			installModule(name, url)
+			summary.add(name, installModule(name, url, moduleConfigs[name]))
 		}
 	} else {
 		var waitGroup sync.WaitGroup
@@ -63,8 +252,8 @@ func InstallModules(modules map[string]string, parallelInstall bool) {
 			waitGroup.Add(1)
 
 			go func() {
-				installModule(name, url)
 				defer waitGroup.Done()
+				summary.add(name, installModule(name, url, moduleConfigs[name]))
 			}()
 		}
 
@@ -76,19 +265,69 @@ func InstallModules(modules map[string]string, parallelInstall bool) {
 		len(modules),
 		time.Since(start),
 	)
+	summary.log()
+}
+
+// Modules that need attention after install, collected from parallel installs
+type installSummary struct {
+	sync.Mutex
+	skipped []string
+	// Module name to clone result, for modules cloned over the other protocol
+	protocolFallbacks map[string]utils.GitCloneResult
 }
 
-func installModule(moduleName string, moduleUrl string) {
+func (summary *installSummary) add(moduleName string, result moduleInstallResult) {
+	summary.Lock()
+	defer summary.Unlock()
+
+	if result.isSkipped {
+		summary.skipped = append(summary.skipped, moduleName)
+	}
+
+	if result.clone.ProtocolFallbackFrom != "" {
+		if summary.protocolFallbacks == nil {
+			summary.protocolFallbacks = map[string]utils.GitCloneResult{}
+		}
+
+		summary.protocolFallbacks[moduleName] = result.clone
+	}
+}
+
+func (summary *installSummary) log() {
+	if len(summary.skipped) > 0 {
+		slices.Sort(summary.skipped)
+		log.Infof(
+			utils.PrepareWarningOutput("Skipped modules with unsaved changes: %s"),
+			strings.Join(summary.skipped, ", "),
+		)
+	}
+
+	for _, moduleName := range getSortedNames(summary.protocolFallbacks) {
+		result := summary.protocolFallbacks[moduleName]
+		log.Warnf(
+			utils.PrepareWarningOutput("Module %s was cloned from %s instead of %s because of auth failure"),
+			moduleName,
+			utils.RedactSecrets(result.Url),
+			utils.RedactSecrets(result.ProtocolFallbackFrom),
+		)
+	}
+}
+
+type moduleInstallResult struct {
+	isSkipped bool
+	clone     utils.GitCloneResult
+}
+
+func installModule(moduleName string, moduleUrl string, moduleConfig ModuleConfig) moduleInstallResult {
 	if !utils.IsGitUrl(moduleUrl) {
-		return
+		return moduleInstallResult{}
 	}
 
-	moduleDir := getModuleDir(moduleName)
+	moduleDir := getModuleDir(moduleName, moduleConfig)
 	err, isModuleNotCloned := checkModuleDirStatus(moduleDir)
 
 	if isModuleNotCloned {
-		utils.GitClone(moduleName, moduleUrl, moduleDir)
-		return
+		return moduleInstallResult{clone: gitCloneModule(moduleName, moduleUrl, moduleDir, moduleConfig)}
 	}
 
 	utils.CheckError(err, "Error while reading module "+moduleName+" folder")
@@ -103,27 +342,91 @@ func installModule(moduleName string, moduleUrl string) {
 			moduleName,
 			gitStatus.String(),
 		)
-		return
+		return moduleInstallResult{isSkipped: true}
 	}
 
 	err = os.RemoveAll(moduleDir)
 	utils.CheckError(err, "Error while trying to delete module folder for "+moduleName)
 
-	utils.GitClone(moduleName, moduleUrl, moduleDir)
+	return moduleInstallResult{clone: gitCloneModule(moduleName, moduleUrl, moduleDir, moduleConfig)}
 }
 
-func ShowChangedModules() {
-	modules, err := os.ReadDir(getModulesDir())
-	utils.CheckError(err, "Error reading modules folder")
+func gitCloneModule(moduleName string, moduleUrl string, moduleDir string, moduleConfig ModuleConfig) utils.GitCloneResult {
+	return utils.GitCloneWithOptions(moduleName, moduleUrl, moduleDir, utils.GitCloneOptions{
+		Mirrors:     moduleConfig.Mirrors,
+		SshIdentity: moduleConfig.GetSshIdentity(),
+	})
+}
+
+// Removes installed modules that have no unsaved changes, modules with changes are skipped
+func PruneModules(modules map[string]string, moduleConfigs map[string]ModuleConfig) {
+	CheckModuleDirs(modules, moduleConfigs)
+
+	for _, moduleName := range getSortedNames(modules) {
+		if !utils.IsGitUrl(modules[moduleName]) {
+			continue
+		}
+
+		moduleDir := getModuleDir(moduleName, moduleConfigs[moduleName])
+		err, isModuleNotCloned := checkModuleDirStatus(moduleDir)
+		if isModuleNotCloned {
+			continue
+		}
+
+		utils.CheckError(err, "Error while reading module "+moduleName+" folder")
+
+		if !utils.IsGitRepo(moduleDir) {
+			log.Infof(utils.PrepareWarningOutput("Module \"%s\" folder is not a git repo - not pruning it"), moduleName)
+			continue
+		}
+
+		gitStatus := utils.GitDirStatus(moduleDir)
+		if gitStatus.String() != "" {
+			log.Infof(
+				utils.PrepareWarningOutput(
+					"\nThere are unsaved changes for module \"%s\" - not pruning it\n"+
+						"\n%s",
+				),
+				moduleName,
+				gitStatus.String(),
+			)
+			continue
+		}
+
+		err = os.RemoveAll(moduleDir)
+		utils.CheckError(err, "Error while trying to delete module folder for "+moduleName)
+		removeEmptyScopeDir(moduleName, moduleConfigs[moduleName])
+		log.Debug(utils.PrepareDangerOutput("Pruned module " + moduleName))
+	}
+}
+
+// Shows modules with unsaved changes: folders inside modules folder and modules with custom path.
+// If onlyModules is not nil, other modules are ignored
+func ShowChangedModules(onlyModules map[string]string, moduleConfigs map[string]ModuleConfig) {
+	moduleDirs := getInstalledModuleDirs()
+
+	for moduleName, moduleConfig := range moduleConfigs {
+		if moduleConfig.Path == "" {
+			continue
+		}
+
+		moduleDir := getModuleDir(moduleName, moduleConfig)
+		if utils.IsGitRepo(moduleDir) {
+			moduleDirs[moduleName] = moduleDir
+		}
+	}
+
 	changedModules := []string{}
 
-	for _, module := range modules {
-		gitStatus := utils.GitDirStatus(
-			getModuleDir(module.Name()),
-		)
+	for _, moduleName := range getSortedNames(moduleDirs) {
+		if _, ok := onlyModules[moduleName]; onlyModules != nil && !ok {
+			continue
+		}
+
+		gitStatus := utils.GitDirStatus(moduleDirs[moduleName])
 
 		if gitStatus.String() != "" {
-			changedModules = append(changedModules, module.Name())
+			changedModules = append(changedModules, moduleName)
 		}
 	}
 
@@ -139,11 +442,167 @@ func ShowChangedModules() {
 	)
 }
 
+// Returns git repos inside modules folder by module name, scope folders are read one level deeper.
+// Other files and folders are not modules, so they are skipped
+func getInstalledModuleDirs() map[string]string {
+	entries, err := os.ReadDir(getModulesDir())
+	utils.CheckError(err, "Error reading modules folder")
+
+	moduleDirs := map[string]string{}
+
+	for _, entry := range entries {
+		moduleDir := filepath.Join(getModulesDir(), entry.Name())
+
+		if utils.IsGitRepo(moduleDir) {
+			moduleDirs[entry.Name()] = moduleDir
+			continue
+		}
+
+		if !isScopedName(entry.Name()) || !entry.IsDir() {
+			continue
+		}
+
+		scopeEntries, err := os.ReadDir(moduleDir)
+		utils.CheckError(err, "Error reading modules scope folder "+entry.Name())
+
+		for _, scopeEntry := range scopeEntries {
+			scopedModuleDir := filepath.Join(moduleDir, scopeEntry.Name())
+
+			if utils.IsGitRepo(scopedModuleDir) {
+				moduleDirs[entry.Name()+"/"+scopeEntry.Name()] = scopedModuleDir
+			}
+		}
+	}
+
+	return moduleDirs
+}
+
+// Scope folder is only a container, so it's removed together with its last module
+func removeEmptyScopeDir(moduleName string, moduleConfig ModuleConfig) {
+	if moduleConfig.Path != "" || !isScopedName(moduleName) {
+		return
+	}
+
+	scopeDir := filepath.Dir(getModuleDir(moduleName, moduleConfig))
+
+	scopeEntries, err := os.ReadDir(scopeDir)
+	if err != nil || len(scopeEntries) > 0 {
+		return
+	}
+
+	err = os.Remove(scopeDir)
+	utils.CheckError(err, "Error while trying to delete empty scope folder for "+moduleName)
+}
+
+func isScopedName(moduleName string) bool {
+	return strings.HasPrefix(moduleName, MODULE_SCOPE_PREFIX)
+}
+
 func checkModuleDirStatus(moduleDir string) (error, bool) {
 	_, err := os.Stat(moduleDir)
 	return err, os.IsNotExist(err)
 }
 
-func getModuleDir(moduleName string) string {
-	return filepath.Join(getModulesDir(), moduleName)
+// Aborts before anything is deleted or cloned if some module folder would end up outside of its root folder,
+// or inside another module folder
+func CheckModuleDirs(modules map[string]string, moduleConfigs map[string]ModuleConfig) {
+	for moduleName := range modules {
+		getModuleDir(moduleName, moduleConfigs[moduleName])
+	}
+
+	collisions := findModuleDirCollisions(modules, moduleConfigs)
+	if len(collisions) > 0 {
+		utils.ThrowError("Module folders collide, installing one module would delete another:\n" + strings.Join(collisions, "\n"))
+	}
+}
+
+// Module folder is guaranteed to be inside modules folder (or inside project for modules with custom path)
+func getModuleDir(moduleName string, moduleConfig ModuleConfig) string {
+	moduleDir, err := resolveModuleDir(moduleName, moduleConfig)
+	utils.CheckError(err, "Invalid folder for module "+moduleName)
+
+	return moduleDir
+}
+
+func resolveModuleDir(moduleName string, moduleConfig ModuleConfig) (string, error) {
+	rootDir := getModulesDir()
+	var moduleDir string
+
+	if moduleConfig.Path == "" {
+		err := validateModuleName(moduleName)
+		if err != nil {
+			return "", err
+		}
+
+		moduleDir = filepath.Join(rootDir, filepath.FromSlash(moduleName))
+	} else {
+		err := validateModulePath(moduleConfig.Path)
+		if err != nil {
+			return "", err
+		}
+
+		rootDir = utils.GetProjectRoot()
+		moduleDir = filepath.Join(rootDir, filepath.FromSlash(moduleConfig.Path))
+	}
+
+	err := checkPathInsideDir(rootDir, moduleDir)
+	if err != nil {
+		return "", err
+	}
+
+	return moduleDir, nil
+}
+
+// Returns sorted descriptions of git modules installed into the same or nested folders.
+// Modules with invalid folders are skipped, they are reported on their own
+func findModuleDirCollisions(modules map[string]string, moduleConfigs map[string]ModuleConfig) []string {
+	moduleDirs := map[string]string{}
+
+	for moduleName, moduleUrl := range modules {
+		if !utils.IsGitUrl(moduleUrl) {
+			continue
+		}
+
+		moduleDir, err := resolveModuleDir(moduleName, moduleConfigs[moduleName])
+		if err != nil {
+			continue
+		}
+
+		moduleDir, err = resolveExistingPath(moduleDir)
+		if err == nil {
+			moduleDirs[moduleName] = moduleDir
+		}
+	}
+
+	collisions := []string{}
+	moduleNames := getSortedNames(moduleDirs)
+
+	for i, moduleName := range moduleNames {
+		for _, otherModuleName := range moduleNames[i+1:] {
+			if !isSameOrNestedDir(moduleDirs[moduleName], moduleDirs[otherModuleName]) {
+				continue
+			}
+
+			collisions = append(collisions, fmt.Sprintf(
+				"modules %s and %s are installed into the same or nested folders %s and %s",
+				moduleName,
+				otherModuleName,
+				moduleDirs[moduleName],
+				moduleDirs[otherModuleName],
+			))
+		}
+	}
+
+	return collisions
+}
+
+func isSameOrNestedDir(dir string, otherDir string) bool {
+	for _, dirs := range [][2]string{{dir, otherDir}, {otherDir, dir}} {
+		relativePath, err := filepath.Rel(dirs[0], dirs[1])
+		if err == nil && (relativePath == "." || filepath.IsLocal(relativePath)) {
+			return true
+		}
+	}
+
+	return false
 }
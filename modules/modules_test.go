@@ -1,9 +1,15 @@
 package modules
 
 import (
+	"bytes"
+	"easymodules/internal/testutil"
 	"easymodules/utils"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/charmbracelet/log"
@@ -22,12 +28,14 @@ type installModuleWant struct {
 }
 
 func TestInstallModule(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+
 	tests := []installModuleTest{
 		{"Not a git module", "not_a_git_module", "5.0.0", installModuleWant{
 			noDir: true,
 		}},
-		{"Simple install", "simple_install", "https://github.com/SergeyDarn/test-module-js.git", installModuleWant{}},
-		{"Git status", "git_status", "https://github.com/SergeyDarn/test-module-js.git", installModuleWant{
+		{"Simple install", "simple_install", testRepo.Url, installModuleWant{}},
+		{"Git status", "git_status", testRepo.Url, installModuleWant{
 			testGitStatus: true,
 		}},
 	}
@@ -35,8 +43,8 @@ func TestInstallModule(t *testing.T) {
 	utils.InitEnv()
 	log.SetLevel(log.ErrorLevel)
 
-	rootModulesDir := utils.GetPathRootDir(getModulesDir())
-	os.RemoveAll(rootModulesDir)
+	// MODULES_DIR from go.env is relative, so modules are installed inside temporary project root
+	t.Setenv("ENV_ROOT", t.TempDir())
 
 	t.Run("InstallModule Group", func(t *testing.T) {
 		for _, test := range tests {
@@ -46,12 +54,10 @@ func TestInstallModule(t *testing.T) {
 			})
 		}
 	})
-
-	os.RemoveAll(rootModulesDir)
 }
 
 func testInstallModule(t *testing.T, test installModuleTest) {
-	moduleDir := getModuleDir(test.moduleName)
+	moduleDir := getModuleDir(test.moduleName, ModuleConfig{})
 
 	var initialGitStatus string
 	if test.want.testGitStatus {
@@ -60,7 +66,7 @@ func testInstallModule(t *testing.T, test installModuleTest) {
 		initialGitStatus = utils.GitDirStatus(moduleDir).String()
 	}
 
-	installModule(test.moduleName, test.moduleUrl)
+	installModule(test.moduleName, test.moduleUrl, ModuleConfig{})
 
 	err, _ := checkModuleDirStatus(moduleDir)
 	if !test.want.noDir && err != nil {
@@ -81,7 +87,7 @@ func testInstallModule(t *testing.T, test installModuleTest) {
 func installModuleWithChanges(t *testing.T, test installModuleTest, moduleDir string) {
 	testFile := "test.txt"
 
-	installModule(test.moduleName, test.moduleUrl)
+	installModule(test.moduleName, test.moduleUrl, ModuleConfig{})
 
 	err, _ := checkModuleDirStatus(moduleDir)
 	if err != nil {
@@ -93,3 +99,374 @@ func installModuleWithChanges(t *testing.T, test installModuleTest, moduleDir st
 		t.Fatal(err.Error())
 	}
 }
+
+func TestReadConfigJson(t *testing.T) {
+	projectRoot := t.TempDir()
+	configFile := filepath.Join(projectRoot, "package.json")
+	configJson := `{
+		"dependencies": {"private": "https://${TEST_DEPLOY_TOKEN}@code.company.com/team/private.git#${TEST_MODULE_REF}"},
+		"devDependencies": {"react": "^19.0.0"},
+		"easyModules": {
+			"private": {"sshKey": "${TEST_KEYS_DIR}/deploy_key", "sshKeyPassword": "${TEST_KEY_PASSWORD}"},
+			"react": {"sshKey": "keys/react_key"}
+		}
+	}`
+
+	err := os.WriteFile(configFile, []byte(configJson), 0o644)
+	utils.CheckTestError(t, err)
+
+	// Relative config file is read from project root
+	t.Setenv("ENV_ROOT", projectRoot)
+	t.Setenv("CONFIG_FILE", "package.json")
+	t.Setenv("TEST_DEPLOY_TOKEN", "deploy-token")
+	t.Setenv("TEST_MODULE_REF", "1.2.0")
+	t.Setenv("TEST_KEYS_DIR", "/keys")
+	t.Setenv("TEST_KEY_PASSWORD", "key-s3cr3t")
+
+	config := ReadConfigJson()
+
+	want := "https://deploy-token@code.company.com/team/private.git#1.2.0"
+	if config.Dependencies["private"] != want {
+		t.Errorf("Expected expanded url %s, but got %s", want, config.Dependencies["private"])
+	}
+
+	if config.DevDependencies["react"] != "^19.0.0" {
+		t.Errorf("Expected non git dependency to be kept as is, but got %s", config.DevDependencies["react"])
+	}
+
+	wantIdentity := utils.SshIdentity{KeyPath: "/keys/deploy_key", KeyPassword: "key-s3cr3t"}
+	if config.Modules["private"].GetSshIdentity() != wantIdentity {
+		t.Errorf("Expected expanded module ssh key %+v, but got %+v", wantIdentity, config.Modules["private"].GetSshIdentity())
+	}
+
+	wantKeyPath := filepath.Join(projectRoot, "keys", "react_key")
+	if config.Modules["react"].SshKey != wantKeyPath {
+		t.Errorf("Expected relative module ssh key to be resolved to %s, but got %s", wantKeyPath, config.Modules["react"].SshKey)
+	}
+
+	if strings.Contains(utils.RedactSecrets("password=key-s3cr3t"), "key-s3cr3t") {
+		t.Errorf("Expected module ssh key password to be redacted")
+	}
+}
+
+func TestGetAllDependencies(t *testing.T) {
+	configJson := JsonConfig{
+		Dependencies: map[string]string{
+			"ui-kit": "git@github.com:company/ui-kit.git#1.2.0",
+			"same":   "git@github.com:company/same.git",
+		},
+		DevDependencies: map[string]string{
+			"ui-kit":  "git@github.com:company/ui-kit.git#dev",
+			"same":    "git@github.com:company/same.git",
+			"testing": "git@github.com:company/testing.git",
+		},
+	}
+
+	allDependencies := configJson.GetAllDependencies()
+
+	if len(allDependencies) != 3 {
+		t.Errorf("Expected 3 merged dependencies, but got %d", len(allDependencies))
+	}
+
+	if allDependencies["ui-kit"] != configJson.Dependencies["ui-kit"] {
+		t.Errorf("Expected dependencies to win over devDependencies, but got %s", allDependencies["ui-kit"])
+	}
+
+	collisions := findDependencyCollisions(configJson)
+	if len(collisions) != 1 || collisions[0] != "ui-kit" {
+		t.Errorf("Expected only ui-kit to collide, but got %v", collisions)
+	}
+}
+
+func TestPruneModules(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	t.Setenv("MODULES_DIR", t.TempDir())
+	log.SetLevel(log.ErrorLevel)
+
+	installModule("clean", testRepo.Url, ModuleConfig{})
+	installModule("changed", testRepo.Url, ModuleConfig{})
+	installModule("kept", testRepo.Url, ModuleConfig{})
+
+	_, err := os.Create(filepath.Join(getModuleDir("changed", ModuleConfig{}), "test.txt"))
+	utils.CheckTestError(t, err)
+
+	PruneModules(map[string]string{
+		"clean":         testRepo.Url,
+		"changed":       testRepo.Url,
+		"not_installed": testRepo.Url,
+	}, nil)
+
+	tests := []struct {
+		moduleName string
+		wantDir    bool
+	}{
+		{"clean", false},
+		{"changed", true},
+		{"kept", true},
+	}
+
+	for _, test := range tests {
+		_, isModuleNotCloned := checkModuleDirStatus(getModuleDir(test.moduleName, ModuleConfig{}))
+
+		if isModuleNotCloned == test.wantDir {
+			t.Errorf("Expected module %s folder to exist: %t, but got %t", test.moduleName, test.wantDir, !isModuleNotCloned)
+		}
+	}
+}
+
+func TestInstallModuleCustomPath(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	projectRoot := t.TempDir()
+	moduleConfig := ModuleConfig{Path: "TEST_CUSTOM_PATH_DIR/themes/theme"}
+	log.SetLevel(log.ErrorLevel)
+
+	t.Setenv("ENV_ROOT", projectRoot)
+	t.Setenv("MODULES_DIR", "src/modules")
+
+	installModule("theme", testRepo.Url, moduleConfig)
+
+	if !utils.IsGitRepo(filepath.Join(projectRoot, filepath.FromSlash(moduleConfig.Path))) {
+		t.Fatalf("Expected module to be installed into %s", moduleConfig.Path)
+	}
+
+	_, isModuleNotCloned := checkModuleDirStatus(filepath.Join(getModulesDir(), "theme"))
+	if !isModuleNotCloned {
+		t.Errorf("Expected module with custom path not to be installed into modules folder")
+	}
+
+	unsafePaths := []string{"../outside", "themes/../../outside", "/etc/theme", ".", "themes/", "src/modules", "src"}
+	for _, unsafePath := range unsafePaths {
+		utils.TestPanic(t, "Unsafe path "+unsafePath, func() {
+			installModule("theme", testRepo.Url, ModuleConfig{Path: unsafePath})
+		})
+	}
+}
+
+func TestInstallModuleHostileNames(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	projectDir := t.TempDir()
+	modulesDir := filepath.Join(projectDir, "modules")
+	t.Setenv("MODULES_DIR", modulesDir)
+	log.SetLevel(log.ErrorLevel)
+
+	sourceFile := filepath.Join(projectDir, "src", "index.js")
+	utils.CheckTestError(t, os.MkdirAll(filepath.Dir(sourceFile), MODULES_DIR_PERMISSIONS))
+	utils.CheckTestError(t, os.WriteFile(sourceFile, []byte("test"), 0o644))
+
+	outsideDir := t.TempDir()
+	utils.CheckTestError(t, os.MkdirAll(modulesDir, MODULES_DIR_PERMISSIONS))
+	utils.CheckTestError(t, os.Symlink(outsideDir, filepath.Join(modulesDir, "@linked")))
+
+	hostileNames := []string{
+		"",
+		".",
+		"..",
+		"../src",
+		"../../src",
+		"ui/../../src",
+		"ui/",
+		"/etc",
+		"..\\src",
+		"@linked/ui",
+	}
+
+	// Scope folder symlinked outside passes name rules and has to be caught by the path check
+	utils.CheckTestError(t, validateModuleName("@linked/ui"))
+	_, err := resolveModuleDir("@linked/ui", ModuleConfig{})
+	if err == nil || !strings.Contains(err.Error(), "resolves outside of") {
+		t.Errorf("Expected symlinked scope folder to resolve outside of modules folder, but got %v", err)
+	}
+
+	for _, hostileName := range hostileNames {
+		utils.TestPanic(t, "Hostile name "+hostileName, func() {
+			installModule(hostileName, testRepo.Url, ModuleConfig{})
+		})
+
+		if entries, _ := os.ReadDir(outsideDir); len(entries) != 0 {
+			t.Fatalf("Expected nothing to be cloned through symlinked folder for %q, but got %d entries", hostileName, len(entries))
+		}
+
+		utils.TestPanic(t, "Removing hostile name "+hostileName, func() {
+			RemoveModules(map[string]string{"ui": testRepo.Url, hostileName: testRepo.Url}, nil)
+		})
+	}
+
+	if _, err := os.Stat(sourceFile); err != nil {
+		t.Errorf("Expected project files outside of modules folder to be kept, but got %s", err.Error())
+	}
+
+	installModule("@company/ui", testRepo.Url, ModuleConfig{})
+	if !utils.IsGitRepo(filepath.Join(modulesDir, "@company", "ui")) {
+		t.Errorf("Expected nested module name to be installed inside modules folder")
+	}
+}
+
+func TestModuleDirCollisions(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	t.Setenv("ENV_ROOT", t.TempDir())
+	t.Setenv("MODULES_DIR", "modules")
+
+	tests := []struct {
+		name          string
+		moduleConfigs map[string]ModuleConfig
+		want          []string
+	}{
+		{"Different folders", map[string]ModuleConfig{
+			"ui-kit": {Path: "vendor/ui"},
+			"theme":  {Path: "vendor/ui-kit"},
+		}, nil},
+		{"Same path", map[string]ModuleConfig{
+			"ui-kit": {Path: "vendor/ui"},
+			"theme":  {Path: "vendor/ui"},
+		}, []string{"modules theme and ui-kit"}},
+		{"Nested path", map[string]ModuleConfig{
+			"ui-kit": {Path: "vendor/ui"},
+			"theme":  {Path: "vendor/ui/themes/theme"},
+		}, []string{"modules theme and ui-kit"}},
+		{"Path inside other module folder", map[string]ModuleConfig{
+			"ui-kit": {Path: "modules/theme/ui-kit"},
+		}, []string{"modules theme and ui-kit"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			modules := map[string]string{"ui-kit": testRepo.Url, "theme": testRepo.Url, "react": "^19.0.0"}
+			collisions := findModuleDirCollisions(modules, test.moduleConfigs)
+
+			if len(collisions) != len(test.want) {
+				t.Fatalf("Expected %d collisions, but got %d: %s", len(test.want), len(collisions), strings.Join(collisions, "; "))
+			}
+
+			for i, want := range test.want {
+				if !strings.Contains(collisions[i], want) {
+					t.Errorf("Expected collision %q to contain %q", collisions[i], want)
+				}
+			}
+
+			problems := validateConfigJson(JsonConfig{Dependencies: modules, Modules: test.moduleConfigs}, false)
+			if len(problems) != len(test.want) {
+				t.Errorf("Expected collisions to be config problems, but got %s", strings.Join(problems, "; "))
+			}
+
+			if len(test.want) > 0 {
+				utils.TestPanic(t, "Install of colliding modules", func() {
+					InstallModules(modules, test.moduleConfigs, false)
+				})
+			}
+		})
+	}
+}
+
+func TestScopedModules(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	modulesDir := t.TempDir()
+	t.Setenv("MODULES_DIR", modulesDir)
+	log.SetLevel(log.ErrorLevel)
+
+	installModule("@company/ui-kit", testRepo.Url, ModuleConfig{})
+	installModule("@company/theme", testRepo.Url, ModuleConfig{})
+	installModule("@other/icons", testRepo.Url, ModuleConfig{})
+	installModule("plain", testRepo.Url, ModuleConfig{})
+
+	// Stray files and a nested folder left by an older version are not modules
+	utils.CheckTestError(t, os.MkdirAll(filepath.Join(modulesDir, "@stray"), MODULES_DIR_PERMISSIONS))
+	utils.CheckTestError(t, os.WriteFile(filepath.Join(modulesDir, "@stray", ".DS_Store"), []byte("test"), 0o644))
+	utils.CheckTestError(t, os.WriteFile(filepath.Join(modulesDir, "notes.txt"), []byte("test"), 0o644))
+	testutil.CreateTestRepo(t, filepath.Join(modulesDir, "company", "ui-kit"))
+
+	moduleDirs := getInstalledModuleDirs()
+	wantNames := []string{"@company/theme", "@company/ui-kit", "@other/icons", "plain"}
+
+	if names := getSortedNames(moduleDirs); strings.Join(names, ",") != strings.Join(wantNames, ",") {
+		t.Fatalf("Expected installed modules %v, but got %v", wantNames, names)
+	}
+
+	for _, moduleName := range wantNames {
+		if moduleDirs[moduleName] != getModuleDir(moduleName, ModuleConfig{}) {
+			t.Errorf("Expected module %s folder %s, but got %s", moduleName, getModuleDir(moduleName, ModuleConfig{}), moduleDirs[moduleName])
+		}
+	}
+
+	ShowChangedModules(nil, nil)
+
+	PruneModules(map[string]string{"@company/ui-kit": testRepo.Url, "@other/icons": testRepo.Url}, nil)
+
+	if _, err := os.Stat(filepath.Join(modulesDir, "@company", "theme")); err != nil {
+		t.Errorf("Expected scope folder with remaining module to be kept, but got %s", err.Error())
+	}
+
+	if _, err := os.Stat(filepath.Join(modulesDir, "@other")); !os.IsNotExist(err) {
+		t.Errorf("Expected empty scope folder to be deleted after pruning its last module")
+	}
+
+	RemoveModules(map[string]string{"@company/theme": testRepo.Url}, nil)
+
+	if _, err := os.Stat(filepath.Join(modulesDir, "@company")); !os.IsNotExist(err) {
+		t.Errorf("Expected empty scope folder to be deleted after removing its last module")
+	}
+
+	for _, invalidName := range []string{"@company", "@company/ui/kit", "company/ui-kit"} {
+		utils.TestPanic(t, "Invalid scoped name "+invalidName, func() {
+			installModule(invalidName, testRepo.Url, ModuleConfig{})
+		})
+	}
+}
+
+func TestInstallSummary(t *testing.T) {
+	fallbackClone := utils.GitCloneResult{Url: "https://code.company.com/team/ui-kit.git", ProtocolFallbackFrom: "git@code.company.com:team/ui-kit.git"}
+	results := map[string]moduleInstallResult{
+		"ui-kit":    {clone: fallbackClone},
+		"checkout":  {isSkipped: true},
+		"analytics": {isSkipped: true},
+		"theme":     {clone: utils.GitCloneResult{Url: "git@code.company.com:team/theme.git"}},
+	}
+
+	var output bytes.Buffer
+	previousLevel := log.GetLevel()
+	log.SetOutput(&output)
+	log.SetLevel(log.InfoLevel)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetLevel(previousLevel)
+	})
+
+	summary := installSummary{}
+	var waitGroup sync.WaitGroup
+
+	for name, result := range results {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+			summary.add(name, result)
+		}()
+	}
+
+	waitGroup.Wait()
+	summary.log()
+
+	slices.Sort(summary.skipped)
+	if !slices.Equal(summary.skipped, []string{"analytics", "checkout"}) {
+		t.Errorf("Expected skipped modules analytics, checkout, but got %v", summary.skipped)
+	}
+
+	wantFallbacks := map[string]utils.GitCloneResult{"ui-kit": fallbackClone}
+	if !maps.Equal(summary.protocolFallbacks, wantFallbacks) {
+		t.Errorf("Expected protocol fallbacks %v, but got %v", wantFallbacks, summary.protocolFallbacks)
+	}
+
+	wantLines := []string{
+		"Skipped modules with unsaved changes: analytics, checkout",
+		"Module ui-kit was cloned from https://code.company.com/team/ui-kit.git instead of git@code.company.com:team/ui-kit.git because of auth failure",
+	}
+
+	for _, wantLine := range wantLines {
+		if !strings.Contains(output.String(), wantLine) {
+			t.Errorf("Expected summary to contain %q, but got:\n%s", wantLine, output.String())
+		}
+	}
+
+	if strings.Contains(output.String(), "theme") {
+		t.Errorf("Expected module installed without problems not to be in summary, but got:\n%s", output.String())
+	}
+}
@@ -0,0 +1,129 @@
+package modules
+
+import (
+	"easymodules/utils"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	DEFAULT_MODULE_GROUP = "default"
+	GROUP_SEPARATOR      = ","
+	GROUP_EXCLUDE_PREFIX = "!"
+)
+
+// Returns modules matching any of the names or glob patterns (e.g. ui-*).
+// Empty patterns select all modules, patterns that match nothing abort with error
+func SelectModules(modules map[string]string, patterns []string) map[string]string {
+	if len(patterns) == 0 {
+		return modules
+	}
+
+	selectedModules := map[string]string{}
+	unknownPatterns := []string{}
+
+	for _, pattern := range patterns {
+		isMatched := false
+
+		for name, url := range modules {
+			isMatch, err := path.Match(pattern, name)
+			utils.CheckError(err, fmt.Sprintf("Invalid module name pattern \"%s\"", pattern))
+
+			if isMatch {
+				selectedModules[name] = url
+				isMatched = true
+			}
+		}
+
+		if !isMatched {
+			unknownPatterns = append(unknownPatterns, pattern)
+		}
+	}
+
+	if len(unknownPatterns) > 0 {
+		utils.ThrowError("Unknown git modules: " + strings.Join(unknownPatterns, ", "))
+	}
+
+	return selectedModules
+}
+
+// Returns modules that belong to groups from comma separated groupSelector, e.g. "ui,checkout".
+// Untagged modules belong to DEFAULT_MODULE_GROUP, groups prefixed with ! are excluded (e.g. "!default")
+func SelectModuleGroups(
+	modules map[string]string,
+	moduleConfigs map[string]ModuleConfig,
+	groupSelector string,
+) map[string]string {
+	includedGroups, excludedGroups := parseGroupSelector(groupSelector)
+	if len(includedGroups) == 0 && len(excludedGroups) == 0 {
+		return modules
+	}
+
+	selectedModules := map[string]string{}
+	matchedGroups := map[string]bool{}
+
+	for name, url := range modules {
+		moduleGroups := getModuleGroups(moduleConfigs[name])
+
+		if slices.ContainsFunc(moduleGroups, func(group string) bool { return slices.Contains(excludedGroups, group) }) {
+			continue
+		}
+
+		isIncluded := len(includedGroups) == 0
+		for _, group := range moduleGroups {
+			if slices.Contains(includedGroups, group) {
+				matchedGroups[group] = true
+				isIncluded = true
+			}
+		}
+
+		if isIncluded {
+			selectedModules[name] = url
+		}
+	}
+
+	unknownGroups := []string{}
+	for _, group := range includedGroups {
+		if !matchedGroups[group] {
+			unknownGroups = append(unknownGroups, group)
+		}
+	}
+
+	if len(unknownGroups) > 0 {
+		utils.ThrowError("No git modules in groups: " + strings.Join(unknownGroups, ", "))
+	}
+
+	return selectedModules
+}
+
+func getModuleGroups(moduleConfig ModuleConfig) []string {
+	if len(moduleConfig.Groups) == 0 {
+		return []string{DEFAULT_MODULE_GROUP}
+	}
+
+	return moduleConfig.Groups
+}
+
+// Returns included, excluded groups
+func parseGroupSelector(groupSelector string) ([]string, []string) {
+	includedGroups := []string{}
+	excludedGroups := []string{}
+
+	for _, group := range strings.Split(groupSelector, GROUP_SEPARATOR) {
+		group = strings.TrimSpace(group)
+
+		if group == "" {
+			continue
+		}
+
+		if strings.HasPrefix(group, GROUP_EXCLUDE_PREFIX) {
+			excludedGroups = append(excludedGroups, strings.TrimPrefix(group, GROUP_EXCLUDE_PREFIX))
+		} else {
+			includedGroups = append(includedGroups, group)
+		}
+	}
+
+	return includedGroups, excludedGroups
+}
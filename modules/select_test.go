@@ -0,0 +1,92 @@
+package modules
+
+import (
+	"easymodules/utils"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestSelectModules(t *testing.T) {
+	modules := map[string]string{
+		"ui-kit":    "git@github.com:company/ui-kit.git",
+		"ui-icons":  "git@github.com:company/ui-icons.git",
+		"checkout":  "git@github.com:company/checkout.git",
+		"admin-app": "git@github.com:company/admin-app.git",
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+		error    bool
+	}{
+		{"No patterns", nil, []string{"admin-app", "checkout", "ui-icons", "ui-kit"}, false},
+		{"Single name", []string{"checkout"}, []string{"checkout"}, false},
+		{"Glob", []string{"ui-*"}, []string{"ui-icons", "ui-kit"}, false},
+		{"Name and glob", []string{"admin-app", "ui-*"}, []string{"admin-app", "ui-icons", "ui-kit"}, false},
+		{"Unknown name", []string{"checkout", "doesnt_exist"}, nil, true},
+		{"Invalid glob", []string{"ui-["}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.error {
+				utils.TestPanic(t, test.name, func() { SelectModules(modules, test.patterns) })
+				return
+			}
+
+			selectedModules := SelectModules(modules, test.patterns)
+			selectedNames := slices.Sorted(maps.Keys(selectedModules))
+
+			if !slices.Equal(selectedNames, test.want) {
+				t.Errorf("Expected %v, but got %v", test.want, selectedNames)
+			}
+		})
+	}
+}
+
+func TestSelectModuleGroups(t *testing.T) {
+	modules := map[string]string{
+		"ui-kit":    "git@github.com:company/ui-kit.git",
+		"checkout":  "git@github.com:company/checkout.git",
+		"admin-app": "git@github.com:company/admin-app.git",
+		"utils":     "git@github.com:company/utils.git",
+	}
+	moduleConfigs := map[string]ModuleConfig{
+		"ui-kit":    {Groups: []string{"ui"}},
+		"checkout":  {Groups: []string{"ui", "checkout"}},
+		"admin-app": {Groups: []string{"admin"}},
+	}
+
+	tests := []struct {
+		name          string
+		groupSelector string
+		want          []string
+		error         bool
+	}{
+		{"No groups", "", []string{"admin-app", "checkout", "ui-kit", "utils"}, false},
+		{"Single group", "ui", []string{"checkout", "ui-kit"}, false},
+		{"Multiple groups", "checkout,admin", []string{"admin-app", "checkout"}, false},
+		{"Default group", "admin,default", []string{"admin-app", "utils"}, false},
+		{"Exclude default group", "!default", []string{"admin-app", "checkout", "ui-kit"}, false},
+		{"Include and exclude", "ui,!checkout", []string{"ui-kit"}, false},
+		{"Unknown group", "ui,doesnt_exist", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.error {
+				utils.TestPanic(t, test.name, func() { SelectModuleGroups(modules, moduleConfigs, test.groupSelector) })
+				return
+			}
+
+			selectedModules := SelectModuleGroups(modules, moduleConfigs, test.groupSelector)
+			selectedNames := slices.Sorted(maps.Keys(selectedModules))
+
+			if !slices.Equal(selectedNames, test.want) {
+				t.Errorf("Expected %v, but got %v", test.want, selectedNames)
+			}
+		})
+	}
+}
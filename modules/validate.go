@@ -0,0 +1,270 @@
+package modules
+
+import (
+	"easymodules/utils"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// Checks config for problems that would otherwise show up halfway through an install.
+// Returns false if any problem was found, all of them are logged at once
+func ValidateConfig(checkRemote bool) bool {
+	problems := getConfigProblems(checkRemote)
+
+	if len(problems) == 0 {
+		log.Info(utils.PrepareSuccessOutput("Config is valid"))
+		return true
+	}
+
+	log.Errorf(
+		utils.PrepareDangerOutput("\nConfig problems (%d):\n\n%s"),
+		len(problems),
+		strings.Join(problems, "\n"),
+	)
+
+	return false
+}
+
+// Config that can't be read or expanded is not validated further, its errors are the problems
+func getConfigProblems(checkRemote bool) []string {
+	configJson, err := readConfigJson()
+	if err == nil {
+		return validateConfigJson(configJson, checkRemote)
+	}
+
+	var joinedErr interface{ Unwrap() []error }
+	if !errors.As(err, &joinedErr) {
+		return []string{utils.RedactSecrets(err.Error())}
+	}
+
+	problems := []string{}
+	for _, expandErr := range joinedErr.Unwrap() {
+		problems = append(problems, utils.RedactSecrets(expandErr.Error()))
+	}
+
+	return problems
+}
+
+func validateConfigJson(configJson JsonConfig, checkRemote bool) []string {
+	problems := []string{}
+
+	for _, name := range findDependencyCollisions(configJson) {
+		problems = append(problems, fmt.Sprintf(
+			"module %s is declared in both dependencies (%s) and devDependencies (%s) with different urls",
+			name,
+			utils.RedactSecrets(configJson.Dependencies[name]),
+			utils.RedactSecrets(configJson.DevDependencies[name]),
+		))
+	}
+
+	allDependencies := configJson.GetAllDependencies()
+
+	for _, name := range getSortedNames(allDependencies) {
+		url := allDependencies[name]
+
+		err := utils.ValidateGitUrl(url)
+		if errors.Is(err, utils.ErrNotGitUrl) {
+			continue
+		}
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("module %s: %s", name, err.Error()))
+			continue
+		}
+
+		// Module with its own path doesn't use its name as a folder, path is checked with module settings
+		if configJson.Modules[name].Path == "" {
+			err = validateModuleName(name)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("module %s: %s", name, err.Error()))
+			}
+		}
+
+		if checkRemote {
+			err = utils.GitCheckRemoteReference(url, configJson.Modules[name].GetSshIdentity())
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("module %s: %s", name, err.Error()))
+			}
+		}
+	}
+
+	for _, name := range getSortedNames(configJson.Modules) {
+		problems = append(problems, validateModuleConfig(configJson, name)...)
+	}
+
+	problems = append(problems, findModuleDirCollisions(allDependencies, configJson.Modules)...)
+
+	return problems
+}
+
+func validateModuleConfig(configJson JsonConfig, name string) []string {
+	problems := []string{}
+	moduleConfig := configJson.Modules[name]
+
+	_, isDependency := configJson.Dependencies[name]
+	_, isDevDependency := configJson.DevDependencies[name]
+	if !isDependency && !isDevDependency {
+		problems = append(problems, fmt.Sprintf("easyModules has settings for unknown module %s", name))
+	}
+
+	if moduleConfig.Path != "" {
+		err := validateModulePath(moduleConfig.Path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("module %s: %s", name, err.Error()))
+		}
+	}
+
+	for _, group := range moduleConfig.Groups {
+		if group == "" || strings.HasPrefix(group, GROUP_EXCLUDE_PREFIX) || strings.Contains(group, GROUP_SEPARATOR) {
+			problems = append(problems, fmt.Sprintf("module %s has invalid group name %q", name, group))
+		}
+	}
+
+	if moduleConfig.SshKeyPassword != "" && moduleConfig.SshKey == "" {
+		problems = append(problems, fmt.Sprintf("module %s has sshKeyPassword without sshKey", name))
+	}
+
+	if moduleConfig.SshKey != "" {
+		_, err := os.Stat(moduleConfig.SshKey)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("module %s sshKey: %s", name, err.Error()))
+		}
+	}
+
+	for _, mirror := range moduleConfig.Mirrors {
+		mirrorUrl, err := utils.NewGitURL(mirror)
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("module %s mirror %s: %s", name, utils.RedactSecrets(mirror), err.Error()))
+		} else if mirrorUrl.Ref != "" {
+			problems = append(problems, fmt.Sprintf("module %s mirror %s must not contain a reference", name, utils.RedactSecrets(mirror)))
+		}
+	}
+
+	return problems
+}
+
+// Module name is used as a folder name inside modules folder, so it must not escape it
+func validateModuleName(name string) error {
+	if name == "" {
+		return errors.New("module name is empty")
+	}
+
+	if name == "." || strings.Contains(name, "\\") || path.Clean(name) != name || !filepath.IsLocal(name) {
+		return fmt.Errorf("module name %q is unsafe as a folder name", name)
+	}
+
+	if isScopedName(name) && len(strings.Split(name, "/")) != 2 {
+		return fmt.Errorf("scoped module name %q must look like @scope/name", name)
+	}
+
+	if !isScopedName(name) && strings.Contains(name, "/") {
+		return fmt.Errorf("module name %q can contain / only after @scope, like @scope/name", name)
+	}
+
+	return nil
+}
+
+// Checks that targetPath resolves strictly inside baseDir, following symlinks of already existing folders
+func checkPathInsideDir(baseDir string, targetPath string) error {
+	resolvedBaseDir, err := resolveExistingPath(baseDir)
+	if err != nil {
+		return err
+	}
+
+	resolvedTargetPath, err := resolveExistingPath(targetPath)
+	if err != nil {
+		return err
+	}
+
+	relativePath, err := filepath.Rel(resolvedBaseDir, resolvedTargetPath)
+	if err != nil || relativePath == "." || !filepath.IsLocal(relativePath) {
+		return fmt.Errorf("%s resolves outside of %s", targetPath, baseDir)
+	}
+
+	return nil
+}
+
+// Returns absolute path with symlinks resolved for the longest existing part of it
+func resolveExistingPath(targetPath string) (string, error) {
+	absolutePath, err := filepath.Abs(targetPath)
+	if err != nil {
+		return "", err
+	}
+
+	existingPath := absolutePath
+	missingPath := ""
+
+	for {
+		resolvedPath, err := filepath.EvalSymlinks(existingPath)
+		if err == nil {
+			return filepath.Join(resolvedPath, missingPath), nil
+		}
+
+		parentPath := filepath.Dir(existingPath)
+		if parentPath == existingPath {
+			return absolutePath, nil
+		}
+
+		missingPath = filepath.Join(filepath.Base(existingPath), missingPath)
+		existingPath = parentPath
+	}
+}
+
+// Custom module path must stay inside project root and must not contain modules folder
+func validateModulePath(modulePath string) error {
+	cleanPath := path.Clean(filepath.ToSlash(modulePath))
+
+	if cleanPath != filepath.ToSlash(modulePath) || cleanPath == "." || !filepath.IsLocal(modulePath) {
+		return fmt.Errorf("module path %q must be a clean relative path inside the project", modulePath)
+	}
+
+	modulesDir, isInsideProject := getProjectRelativePath(getModulesDir())
+	if isInsideProject && (cleanPath == modulesDir || strings.HasPrefix(modulesDir+"/", cleanPath+"/")) {
+		return fmt.Errorf("module path %q must not contain modules folder %s", modulePath, getModulesDir())
+	}
+
+	return nil
+}
+
+// Returns slash separated path relative to project root, false if it's outside of project
+func getProjectRelativePath(targetPath string) (string, bool) {
+	projectRoot, err := filepath.Abs(utils.GetProjectRoot())
+	if err != nil {
+		return "", false
+	}
+
+	absolutePath, err := filepath.Abs(targetPath)
+	if err != nil {
+		return "", false
+	}
+
+	relativePath, err := filepath.Rel(projectRoot, absolutePath)
+	if err != nil || !filepath.IsLocal(relativePath) {
+		return "", false
+	}
+
+	return filepath.ToSlash(relativePath), true
+}
+
+func getSortedNames[T any](configMaps ...map[string]T) []string {
+	names := []string{}
+
+	for _, configMap := range configMaps {
+		for name := range configMap {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,160 @@
+package modules
+
+import (
+	"easymodules/internal/testutil"
+	"easymodules/utils"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfigJson(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	bundlePath := filepath.Join(t.TempDir(), "test-module-js.bundle")
+	testutil.CreateTestBundle(t, testRepo.Repo, bundlePath)
+
+	// Relative local paths are resolved against project root, not against the current folder
+	projectRoot := filepath.Dir(bundlePath)
+	t.Setenv("ENV_ROOT", projectRoot)
+	err := os.Mkdir(filepath.Join(projectRoot, "plain-folder"), 0o755)
+	utils.CheckTestError(t, err)
+
+	tests := []struct {
+		name        string
+		configJson  JsonConfig
+		checkRemote bool
+		want        []string
+	}{
+		{"Valid config", JsonConfig{
+			Dependencies:    map[string]string{"ui-kit": testRepo.Url + "#" + testutil.TEST_REPO_BRANCH, "react": "^19.0.0"},
+			DevDependencies: map[string]string{"@company/theme": bundlePath + "#" + testutil.TEST_REPO_TAG},
+			Modules:         map[string]ModuleConfig{"ui-kit": {Mirrors: []string{bundlePath}}},
+		}, true, nil},
+		{"Duplicate names", JsonConfig{
+			Dependencies:    map[string]string{"ui-kit": testRepo.Url + "#1.0.0", "same": testRepo.Url},
+			DevDependencies: map[string]string{"ui-kit": testRepo.Url + "#dev", "same": testRepo.Url},
+		}, false, []string{"ui-kit is declared in both dependencies"}},
+		{"Invalid urls and refs", JsonConfig{
+			Dependencies: map[string]string{
+				"empty_ref":   testRepo.Url + "#",
+				"invalid_ref": testRepo.Url + "#feature..x",
+			},
+		}, false, []string{"empty_ref: cannot properly parse url", "invalid_ref: invalid git reference"}},
+		{"Unsafe names", JsonConfig{
+			Dependencies: map[string]string{
+				"../../src":      testRepo.Url,
+				"/etc":           testRepo.Url,
+				"@company":       testRepo.Url,
+				"company/ui-kit": testRepo.Url,
+			},
+		}, false, []string{
+			"module ../../src: module name",
+			"module /etc: module name",
+			"module @company: scoped module name",
+			"module company/ui-kit: module name \"company/ui-kit\" can contain / only after @scope",
+		}},
+		{"Custom path instead of name", JsonConfig{
+			Dependencies: map[string]string{"company/ui-kit": testRepo.Url, "../theme": testRepo.Url},
+			Modules: map[string]ModuleConfig{
+				"company/ui-kit": {Path: "src/ui-kit"},
+				"../theme":       {Path: "../theme"},
+			},
+		}, false, []string{"module ../theme: "}},
+		{"Module settings", JsonConfig{
+			Dependencies: map[string]string{"ui-kit": testRepo.Url},
+			Modules: map[string]ModuleConfig{
+				"ui-kit":  {Mirrors: []string{bundlePath + "#dev", "^1.0.0"}},
+				"unknown": {},
+			},
+		}, false, []string{"must not contain a reference", "^1.0.0: not a git url", "unknown module unknown"}},
+		{"Module ssh keys", JsonConfig{
+			Dependencies: map[string]string{"ui-kit": testRepo.Url, "theme": testRepo.Url},
+			Modules: map[string]ModuleConfig{
+				"theme":  {SshKey: filepath.Join(testRepo.Dir, "missing_key")},
+				"ui-kit": {SshKeyPassword: "${UI_KIT_KEY_PASSWORD}"},
+			},
+		}, false, []string{"module theme sshKey: stat", "module ui-kit has sshKeyPassword without sshKey"}},
+		{"Missing remote references", JsonConfig{
+			Dependencies: map[string]string{
+				"missing_branch": testRepo.Url + "#doesnt_exist",
+				"missing_tag":    bundlePath + "#9.9.9",
+			},
+		}, true, []string{"reference doesnt_exist not found", "reference 9.9.9 not found"}},
+		{"Local paths", JsonConfig{
+			Dependencies: map[string]string{
+				"bundle":   "./test-module-js.bundle#" + testutil.TEST_REPO_TAG,
+				"missing":  "./missing-module",
+				"not_repo": "./plain-folder",
+			},
+		}, true, []string{
+			"module missing: local git path ./missing-module doesn't exist",
+			"module not_repo: local git path ./plain-folder is neither a git repo nor a git bundle",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			problems := validateConfigJson(test.configJson, test.checkRemote)
+
+			if len(problems) != len(test.want) {
+				t.Fatalf("Expected %d problems, but got %d: %s", len(test.want), len(problems), strings.Join(problems, "; "))
+			}
+
+			for i, want := range test.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("Expected problem %q to contain %q", problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestGetConfigProblems(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	configDir := t.TempDir()
+
+	t.Setenv("TEST_UI_KIT_REF", testutil.TEST_REPO_BRANCH)
+
+	tests := []struct {
+		name       string
+		configJson string
+		want       []string
+	}{
+		{"Missing config file", "", []string{"error when opening json configuration file"}},
+		{"Invalid json", `{"dependencies": {`, []string{"error when parsing json configuration file"}},
+		{"Unset variables", `{
+			"dependencies": {"ui-kit": "` + testRepo.Url + `#${TEST_UI_KIT_MISSING_REF}"},
+			"easyModules": {"ui-kit": {"mirrors": ["https://${TEST_MIRROR_HOST}/ui-kit.git"]}}
+		}`, []string{
+			"module ui-kit mirror: environment variables are not set: TEST_MIRROR_HOST",
+			"module ui-kit: environment variables are not set: TEST_UI_KIT_MISSING_REF",
+		}},
+		{"Invalid url", `{"dependencies": {"ui-kit": "` + testRepo.Url + `#feature..x"}}`, []string{"module ui-kit: invalid git reference"}},
+		{"Valid config", `{"dependencies": {"ui-kit": "` + testRepo.Url + `#${TEST_UI_KIT_REF}"}}`, nil},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configFile := filepath.Join(configDir, fmt.Sprintf("package_%d.json", i))
+			if test.configJson != "" {
+				err := os.WriteFile(configFile, []byte(test.configJson), 0o644)
+				utils.CheckTestError(t, err)
+			}
+			t.Setenv("CONFIG_FILE", configFile)
+
+			problems := getConfigProblems(false)
+
+			if len(problems) != len(test.want) {
+				t.Fatalf("Expected %d problems, but got %d: %s", len(test.want), len(problems), strings.Join(problems, "; "))
+			}
+
+			for i, want := range test.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("Expected problem %q to contain %q", problems[i], want)
+				}
+			}
+		})
+	}
+}
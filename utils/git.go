@@ -1,21 +1,31 @@
 package utils
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
 	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/charmbracelet/log"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 const (
 	GIT_URL_SEPARATOR = "#"
-	GIT_NORMAL_URL    = "http"
 	TAG_REGEXP        = `\d(\..*)+`
+	HASH_REGEXP       = `^[0-9a-fA-F]{7,40}$`
 )
 
 const (
@@ -26,34 +36,94 @@ const (
 	BRANCH_COLOR = lipgloss.Color("#03dac6")
 )
 
+type GitCloneOptions struct {
+	// Alternative urls (without reference), tried in order if previous url fails with a network error
+	Mirrors []string
+	// Ssh key for this repo, wins over per-host and global keys
+	SshIdentity SshIdentity
+}
+
+type GitCloneResult struct {
+	// Url the repo was cloned from, after rewrites, mirrors and protocol fallback
+	Url string
+	// Url rejected by auth before switching to Url over the other protocol, empty if there was no fallback
+	ProtocolFallbackFrom string
+}
+
 func GitClone(
 	repoName string,
 	repoUrl string,
 	repoDirPath string,
 ) {
+	GitCloneWithOptions(repoName, repoUrl, repoDirPath, GitCloneOptions{})
+}
+
+func GitCloneWithOptions(
+	repoName string,
+	repoUrl string,
+	repoDirPath string,
+	cloneOptions GitCloneOptions,
+) GitCloneResult {
 	repoLog := prepareGitColorOutput("repo="+repoName, REPO_COLOR)
-	urlLog := prepareGitColorOutput("url="+repoUrl, URL_COLOR)
+	urlLog := prepareGitColorOutput("url="+RedactSecrets(repoUrl), URL_COLOR)
 	log.Debugf("Cloning %s %s", repoLog, urlLog)
 
 	cleanModuleUrl, commitHash, branch, tag := parseGitUrl(repoUrl)
+	validateGitMirrors(repoName, cloneOptions.Mirrors)
+
 	reference := branch
 	if tag != "" {
 		reference = tag
 	}
 
-	options := &git.CloneOptions{
-		URL:           cleanModuleUrl,
-		ReferenceName: reference,
-	}
+	var repo *git.Repository
+	var err error
+	var cloneUrl string
+	var result GitCloneResult
+
+	// Origin points to the url from config, not to rewrites, mirrors or protocol fallbacks it was cloned from
+	canonicalUrl := getGitRemoteUrl(cleanModuleUrl)
+
+	candidateUrls := append([]string{cleanModuleUrl}, cloneOptions.Mirrors...)
+	for i, candidateUrl := range candidateUrls {
+		cloneUrl = RewriteGitUrl(candidateUrl)
+		logGitUrlRewrite(repoName, candidateUrl, cloneUrl)
+
+		repo, err = gitCloneUrl(cloneUrl, repoDirPath, reference, cloneOptions.SshIdentity)
+		result.ProtocolFallbackFrom = ""
+
+		fallbackUrl, hasFallbackUrl := getGitProtocolFallbackUrl(candidateUrl)
+		if err != nil && hasFallbackUrl && isGitProtocolFallbackEnabled() && isGitProtocolFallbackError(err) {
+			repo, err = gitCloneProtocolFallback(repoName, cloneUrl, RewriteGitUrl(fallbackUrl), err, repoDirPath, reference, cloneOptions)
+			result.ProtocolFallbackFrom = cloneUrl
+			cloneUrl = RewriteGitUrl(fallbackUrl)
+		}
 
-	auth := getGitAuth(repoUrl)
-	if auth != nil {
-		options.Auth = auth
+		isLastUrl := i == len(candidateUrls)-1
+		if err == nil || isLastUrl || !isNetworkError(err) {
+			break
+		}
+
+		log.Warnf(
+			PrepareWarningOutput("Network error while clonning %s %s: %s - trying next mirror"),
+			repoLog,
+			prepareGitColorOutput("url="+RedactSecrets(cloneUrl), URL_COLOR),
+			RedactSecrets(err.Error()),
+		)
+
+		err = os.RemoveAll(repoDirPath)
+		CheckError(err, "Error while cleaning up after failed clone of "+repoLog)
 	}
 
-	repo, err := git.PlainClone(repoDirPath, false, options)
 	CheckError(err, "Error while clonning "+repoLog)
 
+	result.Url = cloneUrl
+
+	// Clone leaves origin on the url it dialed
+	if getGitDialUrl(cloneUrl) != canonicalUrl {
+		setGitOriginUrl(repo, repoName, canonicalUrl)
+	}
+
 	if commitHash != "" {
 		GitCheckoutToCommit(repo, repoName, commitHash)
 	}
@@ -62,8 +132,150 @@ func GitClone(
 	headColor := getGitColor(commitHash != "", tag != "")
 
 	headLog := prepareGitColorOutput("head="+headName, headColor)
+	usedUrlLog := prepareGitColorOutput("url="+RedactSecrets(cloneUrl), URL_COLOR)
 	successLog := PrepareSuccessOutput("Cloning successful")
-	log.Debugf("%s %s %s", successLog, repoLog, headLog)
+	log.Debugf("%s %s %s %s", successLog, repoLog, headLog, usedUrlLog)
+
+	return result
+}
+
+func gitCloneUrl(
+	cloneUrl string,
+	repoDirPath string,
+	reference plumbing.ReferenceName,
+	sshIdentity SshIdentity,
+) (*git.Repository, error) {
+	options := &git.CloneOptions{
+		URL:           getGitDialUrl(cloneUrl),
+		ReferenceName: reference,
+	}
+
+	auth, authDescription, err := getGitAuthWithError(cloneUrl, sshIdentity)
+	if err != nil {
+		return nil, err
+	}
+	options.Auth = auth
+
+	httpOptions := getGitHttpTransportOptions(cloneUrl)
+	options.CABundle = httpOptions.CABundle
+	options.ClientCert = httpOptions.ClientCert
+	options.ClientKey = httpOptions.ClientKey
+	options.InsecureSkipTLS = httpOptions.InsecureSkipTLS
+	options.ProxyOptions = httpOptions.Proxy
+
+	if authDescription != "" {
+		log.Debugf("Using %s", authDescription)
+	}
+
+	localPath, isLocal := getLocalGitPath(cloneUrl)
+	if isLocal && isGitBundle(localPath) {
+		return gitCloneBundle(localPath, repoDirPath, reference)
+	}
+
+	repo, err := git.PlainClone(repoDirPath, false, options)
+
+	helperAuth, helperDescription, hasHelperAuth := getGitCredentialHelperAuth(cloneUrl, auth, err)
+	if hasHelperAuth {
+		log.Debugf("Server requires auth, using %s", helperDescription)
+
+		err = os.RemoveAll(repoDirPath)
+		CheckError(err, "Error while cleaning up after anonymous clone of "+RedactSecrets(cloneUrl))
+
+		options.Auth = helperAuth
+		authDescription = helperDescription
+		repo, err = git.PlainClone(repoDirPath, false, options)
+		reportGitCredentials(helperAuth, err)
+	}
+
+	return repo, wrapGitTlsError(wrapGitAuthError(err, authDescription))
+}
+
+// Retries clone over the other protocol, failed fallback error keeps the original error for context
+func gitCloneProtocolFallback(
+	repoName string,
+	rejectedUrl string,
+	fallbackUrl string,
+	rejectedErr error,
+	repoDirPath string,
+	reference plumbing.ReferenceName,
+	cloneOptions GitCloneOptions,
+) (*git.Repository, error) {
+	logGitProtocolFallback(repoName, rejectedUrl, fallbackUrl, rejectedErr)
+
+	err := os.RemoveAll(repoDirPath)
+	CheckError(err, "Error while cleaning up after failed clone of repo="+repoName)
+
+	repo, err := gitCloneUrl(fallbackUrl, repoDirPath, reference, cloneOptions.SshIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("%w (fallback from %s, which failed with: %s)", err, rejectedUrl, rejectedErr.Error())
+	}
+
+	return repo, nil
+}
+
+// Returns url as it's stored in origin remote (local paths are made absolute)
+func getGitRemoteUrl(cloneUrl string) string {
+	localPath, isLocal := getLocalGitPath(cloneUrl)
+	if isLocal {
+		return localPath
+	}
+
+	return cloneUrl
+}
+
+func validateGitMirrors(repoName string, mirrors []string) {
+	for _, mirror := range mirrors {
+		mirrorUrl, err := NewGitURL(mirror)
+		CheckError(err, fmt.Sprintf("Invalid mirror url %s for repo=%s", mirror, repoName))
+
+		if mirrorUrl.Ref != "" {
+			ThrowError(fmt.Sprintf("Mirror url %s for repo=%s must not contain a reference", mirror, repoName))
+		}
+	}
+}
+
+// Origin should point at the canonical url, even if module was cloned from a mirror
+func setGitOriginUrl(repo *git.Repository, repoName string, originUrl string) {
+	repoConfig, err := repo.Config()
+	CheckError(err, "Error while reading repo "+repoName+" config")
+
+	origin, ok := repoConfig.Remotes[git.DefaultRemoteName]
+	if !ok {
+		return
+	}
+
+	origin.URLs = []string{originUrl}
+	err = repo.SetConfig(repoConfig)
+	CheckError(err, "Error while setting repo "+repoName+" origin url")
+}
+
+// Only network failures are worth retrying on a mirror, auth and reference errors would fail there too
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var unexpectedErr *plumbing.UnexpectedError
+	if errors.As(err, &unexpectedErr) {
+		err = unexpectedErr.Err
+	}
+
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode() >= http.StatusInternalServerError
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH)
+}
+
+func IsGitRepo(dirPath string) bool {
+	_, err := git.PlainOpen(dirPath)
+	return err == nil
 }
 
 func GitDirStatus(dirPath string) git.Status {
@@ -88,15 +300,17 @@ func GitCheckoutToCommit(
 		return
 	}
 
+	repoLog := "repo=" + repoName
+	fullHash := resolveCommitHash(repo, repoName, commitHash)
+	commitLog := "commitHash=" + fullHash.String()
+
 	workTree, err := repo.Worktree()
 	CheckError(err, fmt.Sprintf("Error while getting repo %s worktree before checkout", repoName))
 
 	err = workTree.Checkout(&git.CheckoutOptions{
-		Hash: plumbing.NewHash(commitHash),
+		Hash: fullHash,
 	})
 
-	repoLog := "repo=" + repoName
-	commitLog := "commitHash=" + commitHash
 	CheckError(err, fmt.Sprintf("Error while trying to checkout %s %s", repoLog, commitLog))
 
 	repoColorLog := prepareGitColorOutput(repoLog, REPO_COLOR)
@@ -104,8 +318,51 @@ func GitCheckoutToCommit(
 	log.Debugf("Sucessful checkout for %s to %s", repoColorLog, commitColorLog)
 }
 
-func IsGitUrl(url string) bool {
-	return strings.Contains(url, "git")
+// Resolves full or abbreviated commitHash against repo commit objects
+func resolveCommitHash(
+	repo *git.Repository,
+	repoName string,
+	commitHash string,
+) plumbing.Hash {
+	commitHash = strings.ToLower(commitHash)
+	if len(commitHash) == len(plumbing.ZeroHash)*2 {
+		return plumbing.NewHash(commitHash)
+	}
+
+	commits, err := repo.CommitObjects()
+	CheckError(err, fmt.Sprintf("Error while getting repo %s commits (resolveCommitHash)", repoName))
+
+	matches := []plumbing.Hash{}
+	commits.ForEach(func(commit *object.Commit) error {
+		if strings.HasPrefix(commit.Hash.String(), commitHash) {
+			matches = append(matches, commit.Hash)
+		}
+
+		return nil
+	})
+
+	repoLog := "repo=" + repoName
+	commitLog := "commitHash=" + commitHash
+
+	if len(matches) == 0 {
+		ThrowError(fmt.Sprintf("Couldn't find commit for %s %s", repoLog, commitLog))
+	}
+
+	if len(matches) > 1 {
+		ambiguousHashes := []string{}
+		for _, match := range matches {
+			ambiguousHashes = append(ambiguousHashes, match.String())
+		}
+
+		ThrowError(fmt.Sprintf(
+			"Ambiguous short hash for %s %s, candidates: %s",
+			repoLog,
+			commitLog,
+			strings.Join(ambiguousHashes, ", "),
+		))
+	}
+
+	return matches[0]
 }
 
 func GetHeadShortName(repo *git.Repository, isCommit bool, isTag bool) string {
@@ -140,19 +397,70 @@ func GetHeadTag(repo *git.Repository) *plumbing.Reference {
 	var headTag *plumbing.Reference
 
 	tags.ForEach(func(tag *plumbing.Reference) error {
-		tagHash, err := repo.ResolveRevision(plumbing.Revision(tag.Name()))
-		CheckError(err, "Error while resolving revision (GetHeadTag)")
+		tagHash, err := peelTag(repo, tag)
+		if err != nil {
+			log.Debugf("Skipping tag %s that can't be resolved to a commit: %s", tag.Name().Short(), err.Error())
+			return nil
+		}
 
-		if *tagHash == head.Hash() {
+		if tagHash == head.Hash() {
 			headTag = tag
 		}
 
 		return nil
 	})
 
+	if headTag != nil {
+		logAnnotatedTag(repo, headTag)
+	}
+
 	return headTag
 }
 
+// Follows annotated (possibly nested) tags down to the commit they point at
+func peelTag(repo *git.Repository, tag *plumbing.Reference) (plumbing.Hash, error) {
+	hash := tag.Hash()
+
+	for {
+		tagObject, err := repo.TagObject(hash)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			break
+		}
+
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+
+		if tagObject.TargetType != plumbing.TagObject && tagObject.TargetType != plumbing.CommitObject {
+			return plumbing.ZeroHash, fmt.Errorf("tag points at a %s, not a commit", tagObject.TargetType)
+		}
+
+		hash = tagObject.Target
+	}
+
+	_, err := repo.CommitObject(hash)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return hash, nil
+}
+
+func logAnnotatedTag(repo *git.Repository, tag *plumbing.Reference) {
+	tagObject, err := repo.TagObject(tag.Hash())
+	if err != nil {
+		return
+	}
+
+	tagLog := prepareGitColorOutput("tag="+tag.Name().Short(), TAG_COLOR)
+	log.Debugf(
+		"Annotated %s tagger=%s message=%s",
+		tagLog,
+		tagObject.Tagger.String(),
+		strings.TrimSpace(tagObject.Message),
+	)
+}
+
 // Returns cleanUrl, commitHash, branch, tag
 func parseGitUrl(gitUrl string) (
 	string,
@@ -160,48 +468,51 @@ func parseGitUrl(gitUrl string) (
 	plumbing.ReferenceName,
 	plumbing.ReferenceName,
 ) {
-	if !IsGitUrl(gitUrl) {
+	parsedUrl, err := NewGitURL(gitUrl)
+	if errors.Is(err, ErrNotGitUrl) {
 		return "", "", "", ""
 	}
 
-	splitUrl := strings.Split(gitUrl, GIT_URL_SEPARATOR)
-	cleanUrl := splitUrl[0]
-	hasReference := len(splitUrl) > 1
+	CheckError(err, "Cannot properly parse url "+gitUrl+" Aborting.")
 
-	if !hasReference {
+	cleanUrl := parsedUrl.String()
+	if parsedUrl.Ref == "" {
 		return cleanUrl, "", "", ""
 	}
 
-	if (splitUrl[1] == "") || (len(splitUrl) > 2) {
-		ThrowError("Cannot properly parse url " + gitUrl + " Aborting.")
-	}
-
-	baseReference := splitUrl[1]
-	commitHash, branch, tag := prepareGitReference(baseReference)
+	commitHash, branch, tag := prepareGitReference(parsedUrl.Ref)
 
 	return cleanUrl, commitHash, branch, tag
 }
 
-// Returns commitHash, branch, tag
-func prepareGitReference(baseReference string) (
+// Returns commitHash, branch, tag (only one of them is set)
+func classifyGitReference(baseReference string) (
 	string,
 	plumbing.ReferenceName,
 	plumbing.ReferenceName,
 ) {
-	var commitHash string
-	var branch plumbing.ReferenceName
-	var tag plumbing.ReferenceName
-
 	tagRegexp, _ := regexp.Compile(TAG_REGEXP)
+	hashRegexp, _ := regexp.Compile(HASH_REGEXP)
 
 	if tagRegexp.MatchString(baseReference) {
-		tag = plumbing.NewTagReferenceName(baseReference)
-	} else if plumbing.IsHash(baseReference) {
-		commitHash = baseReference
-	} else {
-		branch = plumbing.NewBranchReferenceName(baseReference)
+		return "", "", plumbing.NewTagReferenceName(baseReference)
+	}
+
+	if hashRegexp.MatchString(baseReference) {
+		return baseReference, "", ""
 	}
 
+	return "", plumbing.NewBranchReferenceName(baseReference), ""
+}
+
+// Returns commitHash, branch, tag
+func prepareGitReference(baseReference string) (
+	string,
+	plumbing.ReferenceName,
+	plumbing.ReferenceName,
+) {
+	commitHash, branch, tag := classifyGitReference(baseReference)
+
 	baseLog := "Parsed from git url "
 
 	if commitHash != "" {
@@ -222,18 +533,116 @@ func prepareGitReference(baseReference string) (
 	return commitHash, branch, tag
 }
 
-func getGitAuth(repoUrl string) *ssh.PublicKeys {
-	if strings.Contains(repoUrl, GIT_NORMAL_URL) {
+// Checks git url and its reference without network access, returns ErrNotGitUrl for non git values
+func ValidateGitUrl(repoUrl string) error {
+	gitUrl, err := NewGitURL(repoUrl)
+	if err != nil || gitUrl.Ref == "" {
+		return err
+	}
+
+	_, branch, tag := classifyGitReference(gitUrl.Ref)
+	reference := branch
+	if tag != "" {
+		reference = tag
+	}
+
+	if reference != "" && reference.Validate() != nil {
+		return fmt.Errorf("invalid git reference %q", gitUrl.Ref)
+	}
+
+	return nil
+}
+
+// Checks that reference of repoUrl exists on the remote (commit hashes can only be checked if some ref points at them)
+func GitCheckRemoteReference(repoUrl string, sshIdentity SshIdentity) error {
+	cleanUrl, commitHash, branch, tag := parseGitUrl(repoUrl)
+	cloneUrl := RewriteGitUrl(cleanUrl)
+
+	refs, err := listGitRemoteRefs(cloneUrl, sshIdentity)
+	if err != nil {
+		return fmt.Errorf("couldn't list refs of %s: %w", RedactSecrets(cloneUrl), err)
+	}
+
+	reference := branch
+	if tag != "" {
+		reference = tag
+	}
+
+	for name, hash := range refs {
+		if reference != "" && name == reference {
+			return nil
+		}
+
+		if commitHash != "" && strings.HasPrefix(hash.String(), strings.ToLower(commitHash)) {
+			return nil
+		}
+	}
+
+	if reference == "" && commitHash == "" {
+		return nil
+	}
+
+	if commitHash != "" {
+		log.Debugf("Commit %s is not pointed at by any ref of %s, it can't be checked without cloning", commitHash, RedactSecrets(cloneUrl))
 		return nil
 	}
 
-	sshKeyPath := GetEnv(ENV_SSH_KEY_PATH)
-	sshKeyPassword := GetEnv(ENV_SSH_KEY_PASSWORD)
+	return fmt.Errorf("reference %s not found on %s", reference.Short(), RedactSecrets(cloneUrl))
+}
+
+func listGitRemoteRefs(cloneUrl string, sshIdentity SshIdentity) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	localPath, isLocal := getLocalGitPath(cloneUrl)
+	if isLocal && isGitBundle(localPath) {
+		bundle, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer bundle.Close()
 
-	auth, err := ssh.NewPublicKeysFromFile("git", sshKeyPath, sshKeyPassword)
-	CheckError(err, "Error while creating git clone auth")
+		return readGitBundleRefs(bufio.NewReader(bundle))
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{getGitDialUrl(cloneUrl)},
+	})
+
+	auth, authDescription, err := getGitAuthWithError(cloneUrl, sshIdentity)
+	if err != nil {
+		return nil, err
+	}
+	httpOptions := getGitHttpTransportOptions(cloneUrl)
+	listOptions := &git.ListOptions{
+		Auth:            auth,
+		CABundle:        httpOptions.CABundle,
+		ClientCert:      httpOptions.ClientCert,
+		ClientKey:       httpOptions.ClientKey,
+		InsecureSkipTLS: httpOptions.InsecureSkipTLS,
+		ProxyOptions:    httpOptions.Proxy,
+	}
+
+	remoteRefs, err := remote.List(listOptions)
+
+	helperAuth, helperDescription, hasHelperAuth := getGitCredentialHelperAuth(cloneUrl, auth, err)
+	if hasHelperAuth {
+		log.Debugf("Server requires auth, using %s", helperDescription)
+
+		listOptions.Auth = helperAuth
+		authDescription = helperDescription
+		remoteRefs, err = remote.List(listOptions)
+		reportGitCredentials(helperAuth, err)
+	}
+
+	if err != nil {
+		return nil, wrapGitTlsError(wrapGitAuthError(err, authDescription))
+	}
+
+	refs := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range remoteRefs {
+		refs[ref.Name()] = ref.Hash()
+	}
 
-	return auth
+	return refs, nil
 }
 
 func prepareGitColorOutput(output string, color lipgloss.Color) string {
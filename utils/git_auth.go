@@ -0,0 +1,416 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	SSH_AUTH_AUTO  = "auto"
+	SSH_AUTH_AGENT = "agent"
+	SSH_AUTH_KEY   = "key"
+	SSH_AUTH_SOCK  = "SSH_AUTH_SOCK"
+	SSH_GIT_USER   = "git"
+)
+
+var SSH_AUTH_METHODS = []string{SSH_AUTH_AUTO, SSH_AUTH_AGENT, SSH_AUTH_KEY}
+
+// Neither ssh-agent nor key files gave a key to authenticate with
+var ErrNoSshCredentials = errors.New("no usable ssh credentials")
+
+const (
+	SSH_HOST_SETTINGS_SEPARATOR = ","
+	SSH_HOST_SETTING_SEPARATOR  = "="
+	SSH_IDENTITY_SOURCE_MODULE  = "module config"
+	SSH_IDENTITY_SOURCE_CONFIG  = "ssh config"
+)
+
+// Ssh key file with its passphrase. Source tells where key was configured, for logs
+type SshIdentity struct {
+	KeyPath     string
+	KeyPassword string
+	Source      string
+}
+
+// Returns auth for ssh and https urls (nil for other urls or public https repos)
+// and a description of the method for logs and errors, without secrets
+// moduleIdentity is a key set for the module itself, it wins over other ssh keys.
+// Missing ssh credentials are returned as ErrNoSshCredentials, so the clone can fall back to https. Invalid settings abort
+func getGitAuthWithError(repoUrl string, moduleIdentity SshIdentity) (transport.AuthMethod, string, error) {
+	gitUrl, err := NewGitURL(repoUrl)
+	if err != nil {
+		return nil, "", nil
+	}
+
+	if gitUrl.IsHttp() {
+		auth, authDescription := getGitHttpAuth(gitUrl)
+		return auth, authDescription, nil
+	}
+
+	if !gitUrl.IsSsh() {
+		return nil, "", nil
+	}
+
+	return getGitSshAuth(gitUrl, moduleIdentity)
+}
+
+// SSH_AUTH_METHOD selects ssh-agent, key file or auto - agent keys and key files.
+// User and key files come from ssh config for the url host, if it has them
+func getGitSshAuth(gitUrl GitURL, moduleIdentity SshIdentity) (transport.AuthMethod, string, error) {
+	hostConfig := GetSshHostConfig(gitUrl.Host, gitUrl.User, gitUrl.Port)
+	identities, isExplicitIdentity := getSshIdentities(hostConfig, moduleIdentity)
+	authMethod := getSshAuthMethod()
+
+	var signers []gossh.Signer
+	var authDescription string
+	var err error
+
+	switch authMethod {
+	case SSH_AUTH_AGENT:
+		signers, err = getSshAgentSigners()
+		authDescription = describeSshAgentAuth()
+	case SSH_AUTH_KEY:
+		signers, err = getSshKeySigners(identities, true)
+		authDescription = describeSshKeyAuth(identities)
+	default:
+		signers, authDescription, err = getAutoSshSigners(identities, isExplicitIdentity)
+	}
+
+	if err != nil {
+		return nil, "", fmt.Errorf("%w for %s, tried %s: %w", ErrNoSshCredentials, hostConfig.String(), authDescription, err)
+	}
+
+	return newSshSignersAuth(hostConfig, signers), authDescription + " for " + hostConfig.String(), nil
+}
+
+// Most specific keys win: module key, then SSH_HOST_KEYS, then IdentityFile from ssh config, then SSH_KEY_PATH.
+// Module and SSH_HOST_KEYS keys are explicit - they are offered before ssh-agent keys
+func getSshIdentities(hostConfig SshHostConfig, moduleIdentity SshIdentity) ([]SshIdentity, bool) {
+	if moduleIdentity.KeyPath != "" {
+		moduleIdentity.Source = SSH_IDENTITY_SOURCE_MODULE
+		return []SshIdentity{moduleIdentity}, true
+	}
+
+	hostKeys := getSshHostSettings(ENV_SSH_HOST_KEYS, hostConfig)
+	hostPassword, hasHostPassword := getSshHostSetting(getSshHostSettings(ENV_SSH_HOST_KEY_PASSWORDS, hostConfig), hostConfig)
+	if !hasHostPassword {
+		hostPassword = GetEnv(ENV_SSH_KEY_PASSWORD)
+	}
+
+	if hostKey, ok := getSshHostSetting(hostKeys, hostConfig); ok {
+		hostKey, err := ExpandPath(hostKey)
+		CheckError(err, "Error when expanding "+envMap[ENV_SSH_HOST_KEYS]+" key path for "+hostConfig.Alias)
+
+		return []SshIdentity{{KeyPath: hostKey, KeyPassword: hostPassword, Source: envMap[ENV_SSH_HOST_KEYS]}}, true
+	}
+
+	if len(hostConfig.IdentityFiles) > 0 {
+		identities := []SshIdentity{}
+		for _, identityFile := range hostConfig.IdentityFiles {
+			identities = append(identities, SshIdentity{KeyPath: identityFile, KeyPassword: hostPassword, Source: SSH_IDENTITY_SOURCE_CONFIG})
+		}
+
+		return identities, false
+	}
+
+	keyPath := GetEnvPath(ENV_SSH_KEY_PATH)
+	if keyPath == "" {
+		return nil, false
+	}
+
+	return []SshIdentity{{KeyPath: keyPath, KeyPassword: hostPassword, Source: envMap[ENV_SSH_KEY_PATH]}}, false
+}
+
+func getSshHostSettings(env EnvVariable, hostConfig SshHostConfig) map[string]string {
+	settings, err := parseSshHostSettings(GetEnv(env), env == ENV_SSH_HOST_KEY_PASSWORDS)
+	CheckError(err, "Error when parsing "+envMap[env])
+
+	return settings
+}
+
+// Host alias from url wins over its HostName from ssh config
+func getSshHostSetting(settings map[string]string, hostConfig SshHostConfig) (string, bool) {
+	for _, host := range []string{hostConfig.Alias, hostConfig.HostName} {
+		if value, ok := settings[strings.ToLower(host)]; ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// Parses "host=value,host2=value2" settings, ${VAR} placeholders are expanded
+func parseSshHostSettings(rawSettings string, isSecret bool) (map[string]string, error) {
+	settings := map[string]string{}
+
+	for i, rawSetting := range strings.Split(rawSettings, SSH_HOST_SETTINGS_SEPARATOR) {
+		rawSetting = strings.TrimSpace(rawSetting)
+		if rawSetting == "" {
+			continue
+		}
+
+		host, value, found := strings.Cut(rawSetting, SSH_HOST_SETTING_SEPARATOR)
+		if isSecret {
+			AddSecret(value)
+		}
+
+		if !found || strings.TrimSpace(host) == "" {
+			// Entry itself is not printed, it may be a misplaced secret
+			return nil, fmt.Errorf("invalid entry %d, expected host=value", i+1)
+		}
+
+		value, err := ExpandEnvPlaceholders(value)
+		if err != nil {
+			return nil, fmt.Errorf("entry for %s: %w", host, err)
+		}
+
+		if isSecret {
+			AddSecret(value)
+		}
+
+		settings[strings.ToLower(strings.TrimSpace(host))] = value
+	}
+
+	return settings, nil
+}
+
+func getSshAuthMethod() string {
+	authMethod := strings.ToLower(GetEnv(ENV_SSH_AUTH_METHOD))
+	if authMethod == "" {
+		return SSH_AUTH_AUTO
+	}
+
+	for _, method := range SSH_AUTH_METHODS {
+		if authMethod == method {
+			return authMethod
+		}
+	}
+
+	ThrowError(fmt.Sprintf(
+		"Unknown %s %q, expected one of: %s",
+		envMap[ENV_SSH_AUTH_METHOD],
+		authMethod,
+		strings.Join(SSH_AUTH_METHODS, ", "),
+	))
+
+	return ""
+}
+
+// Offers keys from ssh-agent (if it's running) and from key files (if they are set), failing only if both are unavailable.
+// Explicit key files go first, otherwise a wrong agent key may be accepted by the server for another account.
+// Passphrases of other key files are asked only without agent keys, a key in the agent is usually the same key
+func getAutoSshSigners(identities []SshIdentity, isExplicitIdentity bool) ([]gossh.Signer, string, error) {
+	problems := []string{}
+
+	agentSigners, err := getSshAgentSigners()
+	if err != nil {
+		log.Debugf("ssh-agent auth is not used: %s", err.Error())
+		problems = append(problems, "ssh-agent: "+err.Error())
+	}
+
+	var keySigners []gossh.Signer
+	if len(identities) > 0 || len(agentSigners) == 0 {
+		keySigners, err = getSshKeySigners(identities, isExplicitIdentity || len(agentSigners) == 0)
+		if err != nil && isExplicitIdentity {
+			return nil, describeSshKeyAuth(identities), err
+		}
+
+		if err != nil {
+			problems = append(problems, "key file: "+err.Error())
+		}
+	}
+
+	if len(agentSigners) == 0 && len(keySigners) == 0 {
+		return nil, "ssh-agent and key file", errors.New(strings.Join(problems, "\n"))
+	}
+
+	agentAuth := sshSignersWithDescription{agentSigners, describeSshAgentAuth()}
+	keyAuth := sshSignersWithDescription{keySigners, describeSshKeyAuth(identities)}
+
+	orderedAuths := []sshSignersWithDescription{agentAuth, keyAuth}
+	if isExplicitIdentity {
+		orderedAuths = []sshSignersWithDescription{keyAuth, agentAuth}
+	}
+
+	signers := []gossh.Signer{}
+	descriptions := []string{}
+
+	for _, orderedAuth := range orderedAuths {
+		if len(orderedAuth.signers) > 0 {
+			signers = append(signers, orderedAuth.signers...)
+			descriptions = append(descriptions, orderedAuth.description)
+		}
+	}
+
+	return signers, strings.Join(descriptions, ", then "), nil
+}
+
+type sshSignersWithDescription struct {
+	signers     []gossh.Signer
+	description string
+}
+
+// Agent signers sign through the connection, so one connection per socket is kept open while the process runs.
+// Its client is shared too, the client serializes requests from parallel clones
+var sshAgentConnections = struct {
+	sync.Mutex
+	bySocket map[string]sshAgentConnection
+}{bySocket: map[string]sshAgentConnection{}}
+
+type sshAgentConnection struct {
+	connection net.Conn
+	client     agent.ExtendedAgent
+}
+
+func getSshAgentSigners() ([]gossh.Signer, error) {
+	socketPath := os.Getenv(SSH_AUTH_SOCK)
+	if socketPath == "" {
+		return nil, errors.New(SSH_AUTH_SOCK + " is not set")
+	}
+
+	agentConnection, err := getSshAgentConnection(socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signers, err := agentConnection.client.Signers()
+	if err != nil {
+		closeSshAgentConnection(socketPath, agentConnection)
+		return nil, err
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New("agent has no keys, add one with ssh-add")
+	}
+
+	return signers, nil
+}
+
+// Connects to agent on first use, so clones, mirror attempts and ls-remote calls share one connection
+func getSshAgentConnection(socketPath string) (sshAgentConnection, error) {
+	sshAgentConnections.Lock()
+	defer sshAgentConnections.Unlock()
+
+	if agentConnection, ok := sshAgentConnections.bySocket[socketPath]; ok {
+		return agentConnection, nil
+	}
+
+	connection, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return sshAgentConnection{}, fmt.Errorf("cannot connect to %s: %w", socketPath, err)
+	}
+
+	agentConnection := sshAgentConnection{connection: connection, client: agent.NewClient(connection)}
+	sshAgentConnections.bySocket[socketPath] = agentConnection
+
+	return agentConnection, nil
+}
+
+// Broken connection (e.g. agent was restarted) is closed, so the next call connects again
+func closeSshAgentConnection(socketPath string, agentConnection sshAgentConnection) {
+	sshAgentConnections.Lock()
+	defer sshAgentConnections.Unlock()
+
+	if sshAgentConnections.bySocket[socketPath].connection == agentConnection.connection {
+		delete(sshAgentConnections.bySocket, socketPath)
+	}
+
+	agentConnection.connection.Close()
+}
+
+// Key files missing on disk are skipped like in ssh, but at least one key has to be loaded
+func getSshKeySigners(identities []SshIdentity, canAskPassphrase bool) ([]gossh.Signer, error) {
+	if len(identities) == 0 {
+		return nil, errors.New(envMap[ENV_SSH_KEY_PATH] + " is not set and ssh config has no IdentityFile for host")
+	}
+
+	signers := []gossh.Signer{}
+	problems := []string{}
+
+	for _, identity := range identities {
+		signer, err := parseSshPrivateKey(identity, canAskPassphrase)
+		if errors.Is(err, os.ErrNotExist) && len(identities) > 1 {
+			log.Debugf("Skipping missing ssh key file %s", identity.KeyPath)
+			continue
+		}
+
+		if errors.Is(err, errSshKeyPassphraseNotAsked) {
+			log.Debugf("Skipping encrypted ssh key file %s, ssh-agent keys are used instead of asking for its passphrase", identity.KeyPath)
+		}
+
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		if len(problems) == 0 {
+			problems = append(problems, "none of the key files exist")
+		}
+
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+
+	return signers, nil
+}
+
+func newSshSignersAuth(hostConfig SshHostConfig, signers []gossh.Signer) *ssh.PublicKeysCallback {
+	hostKeyCallback, hostKeyAlgorithms := getSshHostKeyCallback(hostConfig)
+
+	return &ssh.PublicKeysCallback{
+		User: hostConfig.User,
+		Callback: func() ([]gossh.Signer, error) {
+			return signers, nil
+		},
+		HostKeyCallbackHelper: ssh.HostKeyCallbackHelper{
+			HostKeyCallback:   hostKeyCallback,
+			HostKeyAlgorithms: hostKeyAlgorithms,
+		},
+	}
+}
+
+func describeSshAgentAuth() string {
+	return "ssh-agent (" + SSH_AUTH_SOCK + "=" + os.Getenv(SSH_AUTH_SOCK) + ")"
+}
+
+func describeSshKeyAuth(identities []SshIdentity) string {
+	keyDescriptions := []string{}
+	for _, identity := range identities {
+		keyDescriptions = append(keyDescriptions, identity.KeyPath+" ("+identity.Source+")")
+	}
+
+	return strings.TrimSpace("key file " + strings.Join(keyDescriptions, ", "))
+}
+
+// Rejected credentials, as opposed to network problems or missing repos
+func isGitAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	return errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// Adds tried auth method to auth errors, so it's clear which credentials were rejected
+func wrapGitAuthError(err error, authDescription string) error {
+	if authDescription == "" || !isGitAuthError(err) {
+		return err
+	}
+
+	return fmt.Errorf("%w (auth method: %s)", err, authDescription)
+}
@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const TEST_SSH_URL = "git@github.com:SergeyDarn/test-module-js.git"
+
+func TestGetGitAuth(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	keyFilePublicKey := createTestSshKey(t, keyPath, "")
+	agentPublicKey, _ := startTestSshAgent(t)
+	agentSocket := os.Getenv(SSH_AUTH_SOCK)
+	useTestSshConfig(t, "")
+
+	tests := []struct {
+		name        string
+		authMethod  string
+		agentSocket string
+		keyPath     string
+		want        []gossh.PublicKey
+		description string
+	}{
+		{"Auto with agent and key file", "", agentSocket, keyPath, []gossh.PublicKey{agentPublicKey, keyFilePublicKey}, "ssh-agent"},
+		{"Auto with agent only", "auto", agentSocket, "", []gossh.PublicKey{agentPublicKey}, "ssh-agent"},
+		{"Auto falls back to key file", "auto", "", keyPath, []gossh.PublicKey{keyFilePublicKey}, "key file"},
+		{"Auto with broken agent", "auto", filepath.Join(t.TempDir(), "missing.sock"), keyPath, []gossh.PublicKey{keyFilePublicKey}, "key file"},
+		{"Agent only", "agent", agentSocket, keyPath, []gossh.PublicKey{agentPublicKey}, "ssh-agent"},
+		{"Key file only", "KEY", agentSocket, keyPath, []gossh.PublicKey{keyFilePublicKey}, "key file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SSH_AUTH_METHOD", test.authMethod)
+			t.Setenv(SSH_AUTH_SOCK, test.agentSocket)
+			t.Setenv("SSH_KEY_PATH", test.keyPath)
+
+			auth, description, err := getGitAuthWithError(TEST_SSH_URL, SshIdentity{})
+			CheckTestError(t, err)
+			if !strings.Contains(description, test.description) {
+				t.Errorf("Expected auth description %q to contain %q", description, test.description)
+			}
+
+			signers, err := auth.(*ssh.PublicKeysCallback).Callback()
+			CheckTestError(t, err)
+
+			if len(signers) != len(test.want) {
+				t.Fatalf("Expected %d keys, but got %d", len(test.want), len(signers))
+			}
+
+			for i, publicKey := range test.want {
+				if string(signers[i].PublicKey().Marshal()) != string(publicKey.Marshal()) {
+					t.Errorf("Expected key %d to be %s", i, gossh.FingerprintSHA256(publicKey))
+				}
+			}
+		})
+	}
+
+	auth, _, err := getGitAuthWithError("https://github.com/SergeyDarn/test-module-js.git", SshIdentity{})
+	CheckTestError(t, err)
+	if auth != nil {
+		t.Errorf("Expected no ssh auth for https url")
+	}
+
+	errorTests := []struct {
+		name        string
+		authMethod  string
+		agentSocket string
+		keyPath     string
+	}{
+		{"Agent without SSH_AUTH_SOCK", "agent", "", keyPath},
+		{"Key file without SSH_KEY_PATH", "key", agentSocket, ""},
+		{"Auto without agent and key file", "auto", "", ""},
+	}
+
+	for _, test := range errorTests {
+		t.Setenv("SSH_AUTH_METHOD", test.authMethod)
+		t.Setenv(SSH_AUTH_SOCK, test.agentSocket)
+		t.Setenv("SSH_KEY_PATH", test.keyPath)
+
+		_, _, err := getGitAuthWithError(TEST_SSH_URL, SshIdentity{})
+		if !errors.Is(err, ErrNoSshCredentials) {
+			t.Errorf("Expected test %s to fail with missing ssh credentials, but got %v", test.name, err)
+		}
+	}
+
+	t.Setenv("SSH_AUTH_METHOD", "password")
+	TestPanic(t, "Unknown method", func() {
+		getGitAuthWithError(TEST_SSH_URL, SshIdentity{})
+	})
+}
+
+func TestGetGitAuthSshConfig(t *testing.T) {
+	keysDir := t.TempDir()
+	workPublicKey := createTestSshKey(t, filepath.Join(keysDir, "id_work"), "")
+	createTestSshKey(t, filepath.Join(keysDir, "id_default"), "")
+
+	useTestSshConfig(t, "Host gitlab-work\n    HostName gitlab.company.com\n    User deploy\n    IdentityFile "+
+		filepath.Join(keysDir, "id_missing")+"\n    IdentityFile "+filepath.Join(keysDir, "id_work")+"\n")
+	t.Setenv("SSH_AUTH_METHOD", "key")
+	t.Setenv("SSH_KEY_PATH", filepath.Join(keysDir, "id_default"))
+
+	auth, description, err := getGitAuthWithError("ssh://gitlab-work/team/repo.git", SshIdentity{})
+	CheckTestError(t, err)
+	keysAuth := auth.(*ssh.PublicKeysCallback)
+
+	if keysAuth.User != "deploy" || !strings.Contains(description, "deploy@gitlab.company.com (gitlab-work)") {
+		t.Errorf("Expected user and host from ssh config, but got user %s and %s", keysAuth.User, description)
+	}
+
+	signers, err := keysAuth.Callback()
+	CheckTestError(t, err)
+
+	if len(signers) != 1 || string(signers[0].PublicKey().Marshal()) != string(workPublicKey.Marshal()) {
+		t.Errorf("Expected only existing IdentityFile key from ssh config to be used instead of SSH_KEY_PATH")
+	}
+
+	auth, _, err = getGitAuthWithError("git@gitlab-work:team/repo.git", SshIdentity{})
+	CheckTestError(t, err)
+	if auth.(*ssh.PublicKeysCallback).User != "git" {
+		t.Errorf("Expected user from url to win over ssh config")
+	}
+}
+
+func TestWrapGitAuthError(t *testing.T) {
+	authError := wrapGitAuthError(transport.ErrAuthenticationRequired, "ssh-agent (SSH_AUTH_SOCK=/tmp/agent.sock)")
+	if !errors.Is(authError, transport.ErrAuthenticationRequired) || !strings.Contains(authError.Error(), "auth method: ssh-agent") {
+		t.Errorf("Expected auth error to name the auth method, but got %s", authError.Error())
+	}
+
+	networkError := errors.New("connection refused")
+	if wrapGitAuthError(networkError, "key file /tmp/id_rsa") != networkError {
+		t.Errorf("Expected non auth errors to be kept as is")
+	}
+}
+
+func TestGetSshIdentities(t *testing.T) {
+	t.Setenv("TEST_WORK_KEY_PASSWORD", "work-s3cr3t")
+	t.Setenv("SSH_KEY_PATH", "/keys/default")
+	t.Setenv("SSH_KEY_PASSWORD", "default-s3cr3t")
+	t.Setenv("SSH_HOST_KEYS", "gitlab-work=/keys/work, GITLAB.company.com=/keys/company")
+	t.Setenv("SSH_HOST_KEY_PASSWORDS", "gitlab-work=${TEST_WORK_KEY_PASSWORD}")
+
+	moduleIdentity := SshIdentity{KeyPath: "/keys/module", KeyPassword: "module-s3cr3t"}
+
+	tests := []struct {
+		name           string
+		hostConfig     SshHostConfig
+		moduleIdentity SshIdentity
+		want           []SshIdentity
+		wantExplicit   bool
+	}{
+		{"Module key wins", SshHostConfig{Alias: "gitlab-work", HostName: "gitlab.company.com"}, moduleIdentity, []SshIdentity{
+			{"/keys/module", "module-s3cr3t", SSH_IDENTITY_SOURCE_MODULE},
+		}, true},
+		{"Host key by alias", SshHostConfig{Alias: "gitlab-work", HostName: "gitlab.company.com"}, SshIdentity{}, []SshIdentity{
+			{"/keys/work", "work-s3cr3t", "SSH_HOST_KEYS"},
+		}, true},
+		{"Host key by ssh config HostName", SshHostConfig{Alias: "company", HostName: "gitlab.company.com"}, SshIdentity{}, []SshIdentity{
+			{"/keys/company", "default-s3cr3t", "SSH_HOST_KEYS"},
+		}, true},
+		{"Ssh config IdentityFile", SshHostConfig{Alias: "gitlab.other.com", HostName: "gitlab.other.com", IdentityFiles: []string{"/keys/a", "/keys/b"}}, SshIdentity{}, []SshIdentity{
+			{"/keys/a", "default-s3cr3t", SSH_IDENTITY_SOURCE_CONFIG},
+			{"/keys/b", "default-s3cr3t", SSH_IDENTITY_SOURCE_CONFIG},
+		}, false},
+		{"Global key", SshHostConfig{Alias: "github.com", HostName: "github.com"}, SshIdentity{}, []SshIdentity{
+			{"/keys/default", "default-s3cr3t", "SSH_KEY_PATH"},
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identities, isExplicit := getSshIdentities(test.hostConfig, test.moduleIdentity)
+
+			if !slices.Equal(identities, test.want) || isExplicit != test.wantExplicit {
+				t.Errorf("Expected %+v (explicit %t), but got %+v (explicit %t)", test.want, test.wantExplicit, identities, isExplicit)
+			}
+		})
+	}
+
+	t.Setenv("SSH_HOST_KEYS", "gitlab-work")
+	TestPanic(t, "Invalid SSH_HOST_KEYS", func() {
+		getSshIdentities(SshHostConfig{Alias: "github.com", HostName: "github.com"}, SshIdentity{})
+	})
+}
+
+func TestGetGitAuthModuleIdentity(t *testing.T) {
+	keysDir := t.TempDir()
+	moduleKeyPath := filepath.Join(keysDir, "id_module")
+	modulePublicKey := createTestSshKey(t, moduleKeyPath, "module-s3cr3t")
+	agentPublicKey, _ := startTestSshAgent(t)
+	useTestSshConfig(t, "")
+
+	t.Setenv("SSH_AUTH_METHOD", "auto")
+	t.Setenv("SSH_HOST_KEYS", "")
+	t.Setenv("SSH_KEY_PATH", "")
+
+	auth, description, err := getGitAuthWithError(TEST_SSH_URL, SshIdentity{KeyPath: moduleKeyPath, KeyPassword: "module-s3cr3t"})
+	CheckTestError(t, err)
+
+	wantDescription := "key file " + moduleKeyPath + " (module config), then ssh-agent"
+	if !strings.HasPrefix(description, wantDescription) || strings.Contains(description, "module-s3cr3t") {
+		t.Errorf("Expected description to start with %q without password, but got %q", wantDescription, description)
+	}
+
+	signers, err := auth.(*ssh.PublicKeysCallback).Callback()
+	CheckTestError(t, err)
+
+	wantKeys := []gossh.PublicKey{modulePublicKey, agentPublicKey}
+	if len(signers) != len(wantKeys) {
+		t.Fatalf("Expected %d keys, but got %d", len(wantKeys), len(signers))
+	}
+
+	for i, publicKey := range wantKeys {
+		if string(signers[i].PublicKey().Marshal()) != string(publicKey.Marshal()) {
+			t.Errorf("Expected explicit module key to be offered before agent keys")
+		}
+	}
+
+	_, _, err = getGitAuthWithError(TEST_SSH_URL, SshIdentity{KeyPath: moduleKeyPath, KeyPassword: "wrong"})
+	if err == nil {
+		t.Errorf("Expected wrong module key password to fail")
+	}
+}
+
+func TestSshAgentConnectionReuse(t *testing.T) {
+	agentPublicKey, getAgentConnections := startTestSshAgent(t)
+
+	var waitGroup sync.WaitGroup
+	for range 8 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			signers, err := getSshAgentSigners()
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+
+			signature, err := signers[0].Sign(rand.Reader, []byte("test"))
+			if err == nil {
+				err = agentPublicKey.Verify([]byte("test"), signature)
+			}
+
+			if err != nil {
+				t.Errorf("Expected agent key to sign through shared connection, but got %s", err.Error())
+			}
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if connections := getAgentConnections(); connections != 1 {
+		t.Errorf("Expected one connection to ssh-agent for all clones, but got %d", connections)
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	GIT_CREDENTIAL_SOURCE = "git credential helper"
+	GIT_CREDENTIAL_FILL   = "fill"
+	GIT_CREDENTIAL_OK     = "approve"
+	GIT_CREDENTIAL_FAILED = "reject"
+)
+
+// Basic auth filled by git credential helpers, reported back to them after use like command-line git does
+type gitCredentialAuth struct {
+	*githttp.BasicAuth
+	gitUrl GitURL
+}
+
+// Credentials from git credential helpers for anonymous request the server refused, like command-line git does.
+// Asking only after 401 keeps public repos anonymous, so stale stored credentials are never used or rejected for them
+func getGitCredentialHelperAuth(cloneUrl string, auth transport.AuthMethod, err error) (*gitCredentialAuth, string, bool) {
+	if auth != nil || !errors.Is(err, transport.ErrAuthenticationRequired) {
+		return nil, "", false
+	}
+
+	gitUrl, parseErr := NewGitURL(cloneUrl)
+	if parseErr != nil || !gitUrl.IsHttp() {
+		return nil, "", false
+	}
+
+	credentials, found := fillGitCredentials(gitUrl)
+	if !found {
+		return nil, "", false
+	}
+
+	helperAuth := &gitCredentialAuth{
+		BasicAuth: &githttp.BasicAuth{Username: credentials.User, Password: credentials.Password},
+		gitUrl:    gitUrl,
+	}
+
+	return helperAuth, describeGitHttpCredentials(credentials), true
+}
+
+// Asks configured git credential helpers (credential-store, cache, osxkeychain etc.) for https host credentials.
+// Git never prompts here, hosts unknown to helpers are cloned anonymously
+func fillGitCredentials(gitUrl GitURL) (GitHttpCredentials, bool) {
+	if !isGitCredentialHelperEnabled() {
+		return GitHttpCredentials{}, false
+	}
+
+	output, err := runGitCredential(GIT_CREDENTIAL_FILL, gitUrl, GitHttpCredentials{User: gitUrl.User})
+	if err != nil {
+		log.Debugf("No credentials from %s for %s: %s", GIT_CREDENTIAL_SOURCE, gitUrl.Host, err.Error())
+		return GitHttpCredentials{}, false
+	}
+
+	credentials := GitHttpCredentials{Host: getGitCredentialHost(gitUrl), Source: GIT_CREDENTIAL_SOURCE}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+
+		switch key {
+		case "username":
+			credentials.User = value
+		case "password":
+			AddSecret(value)
+			credentials.Password = value
+		}
+	}
+
+	if credentials.Password == "" {
+		return GitHttpCredentials{}, false
+	}
+
+	return credentials, true
+}
+
+// Approves credentials that worked and rejects the ones server refused, other errors tell nothing about them
+func reportGitCredentials(auth transport.AuthMethod, err error) {
+	credentialAuth, ok := auth.(*gitCredentialAuth)
+	if !ok {
+		return
+	}
+
+	action := GIT_CREDENTIAL_OK
+	if err != nil {
+		if !isGitAuthError(err) {
+			return
+		}
+
+		action = GIT_CREDENTIAL_FAILED
+	}
+
+	credentials := GitHttpCredentials{User: credentialAuth.Username, Password: credentialAuth.Password}
+	_, reportErr := runGitCredential(action, credentialAuth.gitUrl, credentials)
+	if reportErr != nil {
+		log.Warnf(PrepareWarningOutput("Couldn't %s credentials for %s in %s: %s"), action, credentialAuth.gitUrl.Host, GIT_CREDENTIAL_SOURCE, reportErr.Error())
+	}
+}
+
+func runGitCredential(action string, gitUrl GitURL, credentials GitHttpCredentials) (string, error) {
+	input := fmt.Sprintf("protocol=%s\nhost=%s\n", gitUrl.Scheme, getGitCredentialHost(gitUrl))
+	if credentials.User != "" {
+		input += "username=" + credentials.User + "\n"
+	}
+	if credentials.Password != "" {
+		input += "password=" + credentials.Password + "\n"
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	command := exec.Command("git", "credential", action)
+	command.Stdin = strings.NewReader(input + "\n")
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	// Without a terminal prompt git fails fill instead of asking for username and password
+	command.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+
+	err := command.Run()
+	if err != nil {
+		return "", fmt.Errorf("git credential %s: %w: %s", action, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
+
+// Enabled by default when git is installed, GIT_CREDENTIAL_HELPERS=false turns it off
+func isGitCredentialHelperEnabled() bool {
+	if GetEnv(ENV_GIT_CREDENTIAL_HELPERS) != "" && !GetEnvBool(ENV_GIT_CREDENTIAL_HELPERS) {
+		return false
+	}
+
+	_, err := exec.LookPath("git")
+	if errors.Is(err, exec.ErrNotFound) {
+		log.Debugf("git is not installed, %s is not used", GIT_CREDENTIAL_SOURCE)
+		return false
+	}
+
+	return err == nil
+}
+
+func getGitCredentialHost(gitUrl GitURL) string {
+	if gitUrl.Port == "" {
+		return gitUrl.Host
+	}
+
+	return gitUrl.Host + ":" + gitUrl.Port
+}
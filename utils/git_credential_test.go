@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"easymodules/internal/testutil"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+func TestGitCredentialHelper(t *testing.T) {
+	reposDir := t.TempDir()
+	testutil.CreateTestRepo(t, filepath.Join(reposDir, "repo"))
+	server := startTestGitHttpServer(t, reposDir, "deploy", "helper-s3cr3t")
+	repoUrl := server.URL + "/repo/.git"
+
+	t.Setenv("GIT_HTTP_CREDENTIALS", "")
+	t.Setenv(NETRC_ENV, filepath.Join(t.TempDir(), "missing"))
+
+	tests := []struct {
+		name       string
+		password   string
+		wantError  bool
+		wantAction string
+	}{
+		{"Approve working credentials", "helper-s3cr3t", false, "store"},
+		{"Reject refused credentials", "wrong-s3cr3t", true, "erase"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			helperLogPath := useTestGitCredentialHelper(t, "deploy", test.password)
+
+			_, err := gitCloneUrl(repoUrl, t.TempDir(), "", SshIdentity{})
+
+			if test.wantError {
+				if !errors.Is(err, transport.ErrAuthenticationRequired) || !strings.Contains(err.Error(), "from git credential helper") {
+					t.Fatalf("Expected authentication error naming credential helper, but got %v", err)
+				}
+			} else {
+				CheckTestError(t, err)
+			}
+
+			helperLog, err := os.ReadFile(helperLogPath)
+			CheckTestError(t, err)
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			wantLog := "get host=" + host + " \n" +
+				test.wantAction + " host=" + host + " username=deploy password=" + test.password + " \n"
+			if string(helperLog) != wantLog {
+				t.Errorf("Expected helper calls:\n%s\nbut got:\n%s", wantLog, helperLog)
+			}
+		})
+	}
+
+	t.Run("Public repo with stale credentials", func(t *testing.T) {
+		helperLogPath := useTestGitCredentialHelper(t, "deploy", "stale-s3cr3t")
+
+		// Like public repos on GitHub: anonymous access works, wrong credentials are refused
+		publicHandler := newTestGitHttpHandler(t, reposDir, "", "")
+		publicServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if _, _, hasAuth := request.BasicAuth(); hasAuth {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			publicHandler.ServeHTTP(writer, request)
+		}))
+		t.Cleanup(publicServer.Close)
+
+		_, err := gitCloneUrl(publicServer.URL+"/repo/.git", t.TempDir(), "", SshIdentity{})
+		CheckTestError(t, err)
+
+		if _, err := os.Stat(helperLogPath); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected credential helper not to be called for public repo")
+		}
+	})
+
+	useTestGitCredentialHelper(t, "deploy", "helper-s3cr3t")
+	t.Setenv("GIT_CREDENTIAL_HELPERS", "false")
+	if _, _, found := getGitCredentialHelperAuth(repoUrl, nil, transport.ErrAuthenticationRequired); found {
+		t.Errorf("Expected credential helpers to be skipped when disabled")
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// GIT_PROTOCOL_FALLBACK=true lets ssh urls be cloned over https and https urls over ssh
+// when auth is rejected or there are no credentials for the original protocol
+func isGitProtocolFallbackEnabled() bool {
+	return GetEnvBool(ENV_GIT_PROTOCOL_FALLBACK)
+}
+
+// Network and reference errors would repeat over the other protocol, only auth problems are worth a retry
+func isGitProtocolFallbackError(err error) bool {
+	return errors.Is(err, ErrNoSshCredentials) || isGitAuthError(err)
+}
+
+// Returns the same repo over the other protocol: https://host/path for ssh urls (ssh config alias is resolved
+// to its HostName) and ssh://host/path for http(s) urls (user comes from ssh config, git by default).
+// Users, passwords and ports are dropped, they belong to the original protocol
+func getGitProtocolFallbackUrl(repoUrl string) (string, bool) {
+	gitUrl, err := NewGitURL(repoUrl)
+	if err != nil || gitUrl.Local {
+		return "", false
+	}
+
+	fallbackUrl := GitURL{Path: "/" + strings.TrimPrefix(gitUrl.Path, "/")}
+
+	switch {
+	case gitUrl.IsSsh():
+		fallbackUrl.Scheme = "https"
+		fallbackUrl.Host = GetSshHostConfig(gitUrl.Host, "", "").HostName
+	case gitUrl.IsHttp():
+		fallbackUrl.Scheme = "ssh"
+		fallbackUrl.Host = gitUrl.Host
+	default:
+		return "", false
+	}
+
+	return fallbackUrl.String(), true
+}
+
+func logGitProtocolFallback(repoName string, fromUrl string, toUrl string, err error) {
+	log.Warnf(
+		PrepareWarningOutput("Auth failed while clonning %s %s: %s - %s is on, trying %s"),
+		prepareGitColorOutput("repo="+repoName, REPO_COLOR),
+		prepareGitColorOutput("url="+RedactSecrets(fromUrl), URL_COLOR),
+		RedactSecrets(err.Error()),
+		envMap[ENV_GIT_PROTOCOL_FALLBACK],
+		prepareGitColorOutput("url="+RedactSecrets(toUrl), URL_COLOR),
+	)
+}
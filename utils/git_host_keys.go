@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/skeema/knownhosts"
+	gossh "golang.org/x/crypto/ssh"
+	xknownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	SSH_HOST_KEY_POLICY_STRICT     = "strict"
+	SSH_HOST_KEY_POLICY_ACCEPT_NEW = "accept-new"
+	SSH_HOST_KEY_POLICY_PINNED     = "pinned"
+	SSH_KNOWN_HOSTS_ENV            = "SSH_KNOWN_HOSTS"
+	SSH_DEFAULT_PORT               = "22"
+	// Defaults for project-local files, relative to project root
+	DEFAULT_SSH_PROJECT_KNOWN_HOSTS_FILE = "known_hosts"
+	DEFAULT_SSH_HOST_FINGERPRINTS_FILE   = "ssh_host_fingerprints"
+)
+
+var SSH_HOST_KEY_POLICIES = []string{SSH_HOST_KEY_POLICY_STRICT, SSH_HOST_KEY_POLICY_ACCEPT_NEW, SSH_HOST_KEY_POLICY_PINNED}
+
+// Parallel clones may accept the same new host at once
+var projectKnownHostsMutex sync.Mutex
+
+// Returns host key callback for SSH_HOST_KEY_POLICY and host key algorithms to ask the server for:
+// strict - only hosts from user and project known_hosts, accept-new - unknown hosts are added to project known_hosts,
+// pinned - only fingerprints from SSH_HOST_FINGERPRINTS_FILE
+func getSshHostKeyCallback(hostConfig SshHostConfig) (gossh.HostKeyCallback, []string) {
+	policy := getSshHostKeyPolicy()
+
+	if policy == SSH_HOST_KEY_POLICY_PINNED {
+		fingerprintsFile := getSshHostFingerprintsFile()
+		fingerprints, err := readSshHostFingerprints(fingerprintsFile)
+		CheckError(err, "Error when reading pinned ssh host fingerprints "+fingerprintsFile)
+
+		return newPinnedHostKeyCallback(fingerprints, fingerprintsFile), nil
+	}
+
+	knownHostsFiles := getSshKnownHostsFiles()
+	knownHostsDb, err := newSshKnownHostsDb(knownHostsFiles)
+	CheckError(err, "Error when reading known_hosts files "+strings.Join(knownHostsFiles, ", "))
+
+	hostWithPort := hostConfig.getHostWithPort()
+	callback := newKnownHostsCallback(knownHostsDb, knownHostsFiles, policy)
+
+	if knownHostsDb == nil {
+		return callback, nil
+	}
+
+	return callback, knownHostsDb.HostKeyAlgorithms(hostWithPort)
+}
+
+func getSshHostKeyPolicy() string {
+	policy := strings.ToLower(GetEnv(ENV_SSH_HOST_KEY_POLICY))
+	if policy == "" {
+		return SSH_HOST_KEY_POLICY_STRICT
+	}
+
+	if !slices.Contains(SSH_HOST_KEY_POLICIES, policy) {
+		ThrowError(fmt.Sprintf(
+			"Unknown %s %q, expected one of: %s",
+			envMap[ENV_SSH_HOST_KEY_POLICY],
+			policy,
+			strings.Join(SSH_HOST_KEY_POLICIES, ", "),
+		))
+	}
+
+	return policy
+}
+
+func getSshProjectKnownHostsFile() string {
+	if knownHostsFile := GetEnvPath(ENV_SSH_PROJECT_KNOWN_HOSTS_FILE); knownHostsFile != "" {
+		return knownHostsFile
+	}
+
+	return filepath.Join(GetProjectRoot(), DEFAULT_SSH_PROJECT_KNOWN_HOSTS_FILE)
+}
+
+func getSshHostFingerprintsFile() string {
+	if fingerprintsFile := GetEnvPath(ENV_SSH_HOST_FINGERPRINTS_FILE); fingerprintsFile != "" {
+		return fingerprintsFile
+	}
+
+	return filepath.Join(GetProjectRoot(), DEFAULT_SSH_HOST_FINGERPRINTS_FILE)
+}
+
+// User files are the same as in go-git (SSH_KNOWN_HOSTS or ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts),
+// project file goes last
+func getSshKnownHostsFiles() []string {
+	knownHostsFiles := filepath.SplitList(os.Getenv(SSH_KNOWN_HOSTS_ENV))
+
+	if len(knownHostsFiles) == 0 {
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			knownHostsFiles = append(knownHostsFiles, filepath.Join(homeDir, ".ssh", "known_hosts"))
+		}
+
+		knownHostsFiles = append(knownHostsFiles, filepath.Join("/", "etc", "ssh", "ssh_known_hosts"))
+	}
+
+	return append(knownHostsFiles, getSshProjectKnownHostsFile())
+}
+
+// Missing files are skipped, returns nil db if none of the files exist
+func newSshKnownHostsDb(knownHostsFiles []string) (*knownhosts.HostKeyDB, error) {
+	existingFiles := []string{}
+
+	for _, knownHostsFile := range knownHostsFiles {
+		_, err := os.Stat(knownHostsFile)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		existingFiles = append(existingFiles, knownHostsFile)
+	}
+
+	if len(existingFiles) == 0 {
+		return nil, nil
+	}
+
+	return knownhosts.NewDB(existingFiles...)
+}
+
+func newKnownHostsCallback(knownHostsDb *knownhosts.HostKeyDB, knownHostsFiles []string, policy string) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		// Without any known_hosts files every host is unknown
+		var err error = &xknownhosts.KeyError{}
+		if knownHostsDb != nil {
+			err = knownHostsDb.HostKeyCallback()(hostname, remote, key)
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		var keyError *xknownhosts.KeyError
+		if !errors.As(err, &keyError) {
+			return err
+		}
+
+		if len(keyError.Want) > 0 {
+			return newChangedHostKeyError(hostname, key, keyError.Want)
+		}
+
+		if policy == SSH_HOST_KEY_POLICY_ACCEPT_NEW {
+			return acceptNewHostKey(getSshProjectKnownHostsFile(), hostname, remote, key)
+		}
+
+		return newUnknownHostKeyError(hostname, key, knownHostsFiles)
+	}
+}
+
+// Appends host key to project known_hosts, unless another clone has just added it
+func acceptNewHostKey(knownHostsFile string, hostname string, remote net.Addr, key gossh.PublicKey) error {
+	projectKnownHostsMutex.Lock()
+	defer projectKnownHostsMutex.Unlock()
+
+	knownHostsDb, err := newSshKnownHostsDb([]string{knownHostsFile})
+	if err != nil {
+		return err
+	}
+
+	if knownHostsDb != nil {
+		err = knownHostsDb.HostKeyCallback()(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyError *xknownhosts.KeyError
+		if errors.As(err, &keyError) && len(keyError.Want) > 0 {
+			return newChangedHostKeyError(hostname, key, keyError.Want)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(knownHostsFile), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(knownHostsFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	if err != nil {
+		return err
+	}
+
+	log.Warnf(
+		PrepareWarningOutput("Trusted new ssh host %s with key %s %s, added to %s"),
+		knownhosts.Normalize(hostname),
+		key.Type(),
+		gossh.FingerprintSHA256(key),
+		knownHostsFile,
+	)
+
+	return nil
+}
+
+func newUnknownHostKeyError(hostname string, key gossh.PublicKey, knownHostsFiles []string) error {
+	host, port := splitSshHostPort(hostname)
+
+	return fmt.Errorf(
+		"ssh host %s is unknown - its key %s %s is not in %s.\n"+
+			"Check this fingerprint with the server admins, then either:\n"+
+			"  - add the host to known_hosts: ssh-keyscan -p %s %s >> %s\n"+
+			"  - set %s=%s to trust new hosts on first use (they are saved to %s)\n"+
+			"  - set %s=%s and pin the fingerprint in %s",
+		knownhosts.Normalize(hostname),
+		key.Type(),
+		gossh.FingerprintSHA256(key),
+		strings.Join(knownHostsFiles, ", "),
+		port,
+		host,
+		getSshProjectKnownHostsFile(),
+		envMap[ENV_SSH_HOST_KEY_POLICY],
+		SSH_HOST_KEY_POLICY_ACCEPT_NEW,
+		getSshProjectKnownHostsFile(),
+		envMap[ENV_SSH_HOST_KEY_POLICY],
+		SSH_HOST_KEY_POLICY_PINNED,
+		getSshHostFingerprintsFile(),
+	)
+}
+
+func newChangedHostKeyError(hostname string, key gossh.PublicKey, knownKeys []xknownhosts.KnownKey) error {
+	knownKeyDescriptions := []string{}
+	for _, knownKey := range knownKeys {
+		knownKeyDescriptions = append(knownKeyDescriptions, fmt.Sprintf(
+			"%s %s (%s:%d)",
+			knownKey.Key.Type(),
+			gossh.FingerprintSHA256(knownKey.Key),
+			knownKey.Filename,
+			knownKey.Line,
+		))
+	}
+
+	return fmt.Errorf(
+		"ssh host key for %s has CHANGED: server sent %s %s, but known keys are %s.\n"+
+			"Someone may be intercepting the connection, or the server was reinstalled - "+
+			"check with the server admins before removing the old key",
+		knownhosts.Normalize(hostname),
+		key.Type(),
+		gossh.FingerprintSHA256(key),
+		strings.Join(knownKeyDescriptions, ", "),
+	)
+}
+
+func newPinnedHostKeyCallback(fingerprints map[string][]string, fingerprintsFile string) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		host := knownhosts.Normalize(hostname)
+		fingerprint := gossh.FingerprintSHA256(key)
+
+		hostFingerprints, ok := fingerprints[host]
+		if !ok {
+			return fmt.Errorf(
+				"ssh host %s is not pinned in %s. If %s %s is the right key (check with the server admins), add line: %s %s",
+				host, fingerprintsFile, key.Type(), fingerprint, host, fingerprint,
+			)
+		}
+
+		if !slices.Contains(hostFingerprints, fingerprint) {
+			return fmt.Errorf(
+				"ssh host key for %s does NOT match pinned fingerprints in %s: server sent %s %s, pinned %s.\n"+
+					"Someone may be intercepting the connection, or the server key was rotated - check with the server admins",
+				host, fingerprintsFile, key.Type(), fingerprint, strings.Join(hostFingerprints, ", "),
+			)
+		}
+
+		return nil
+	}
+}
+
+// Reads "host SHA256:fingerprint" lines (host with non-default port is [host]:port), # starts a comment
+func readSshHostFingerprints(fingerprintsFile string) (map[string][]string, error) {
+	file, err := os.Open(fingerprintsFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fingerprints := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "SHA256:") {
+			return nil, fmt.Errorf("line %d: expected \"host SHA256:fingerprint\"", lineNumber)
+		}
+
+		host := knownhosts.Normalize(fields[0])
+		fingerprints[host] = append(fingerprints[host], fields[1])
+	}
+
+	return fingerprints, scanner.Err()
+}
+
+func splitSshHostPort(hostname string) (string, string) {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return hostname, SSH_DEFAULT_PORT
+	}
+
+	return host, port
+}
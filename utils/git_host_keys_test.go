@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/skeema/knownhosts"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestSshHostKeyPolicy(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	clientKey := createTestSshKey(t, keyPath, "")
+	serverAddress, serverHostKey := startTestSshServer(t, clientKey)
+	_, otherHostKey := startTestSshServer(t)
+
+	useTestSshConfig(t, "")
+	t.Setenv("SSH_AUTH_METHOD", "key")
+	t.Setenv("SSH_KEY_PATH", keyPath)
+	t.Setenv("SSH_HOST_KEYS", "")
+	t.Setenv(SSH_KNOWN_HOSTS_ENV, filepath.Join(t.TempDir(), "missing_known_hosts"))
+
+	serverHost, serverPort := splitSshHostPort(serverAddress)
+	serverLine := knownhosts.Line([]string{knownhosts.Normalize(serverAddress)}, serverHostKey) + "\n"
+	changedLine := knownhosts.Line([]string{knownhosts.Normalize(serverAddress)}, otherHostKey) + "\n"
+	serverPin := "[" + serverHost + "]:" + serverPort + " " + gossh.FingerprintSHA256(serverHostKey) + " # test server\n"
+	otherPin := "[" + serverHost + "]:" + serverPort + " " + gossh.FingerprintSHA256(otherHostKey) + "\n"
+
+	tests := []struct {
+		name              string
+		policy            string
+		knownHosts        string
+		fingerprints      string
+		wantError         string
+		wantKnownHosts    string
+		wantKnownHostsSet bool
+	}{
+		{"Strict unknown host", "", "", "", "is unknown", "", false},
+		{"Strict known host", "strict", serverLine, "", "", serverLine, true},
+		{"Strict changed key", "strict", changedLine, "", "has CHANGED", changedLine, true},
+		{"Accept new host", "accept-new", "", "", "", serverLine, true},
+		{"Accept new keeps changed key", "accept-new", changedLine, "", "has CHANGED", changedLine, true},
+		{"Pinned fingerprint", "pinned", "", serverPin, "", "", false},
+		{"Pinned mismatch", "pinned", serverLine, otherPin, "does NOT match", serverLine, true},
+		{"Not pinned host", "pinned", "", "github.com SHA256:abc\n", "is not pinned", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			projectDir := t.TempDir()
+			knownHostsFile := filepath.Join(projectDir, "known_hosts")
+			fingerprintsFile := filepath.Join(projectDir, "ssh_host_fingerprints")
+
+			t.Setenv("SSH_HOST_KEY_POLICY", test.policy)
+			t.Setenv("SSH_PROJECT_KNOWN_HOSTS_FILE", knownHostsFile)
+			t.Setenv("SSH_HOST_FINGERPRINTS_FILE", fingerprintsFile)
+
+			if test.knownHosts != "" {
+				CheckTestError(t, os.WriteFile(knownHostsFile, []byte(test.knownHosts), 0o644))
+			}
+
+			if test.fingerprints != "" {
+				CheckTestError(t, os.WriteFile(fingerprintsFile, []byte(test.fingerprints), 0o644))
+			}
+
+			err := dialTestSshServer(t, serverAddress)
+
+			if test.wantError == "" {
+				CheckTestError(t, err)
+			} else if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Fatalf("Expected error containing %q, but got %v", test.wantError, err)
+			}
+
+			knownHosts, _ := os.ReadFile(knownHostsFile)
+			if test.wantKnownHostsSet && string(knownHosts) != test.wantKnownHosts {
+				t.Errorf("Expected project known_hosts:\n%s\nbut got:\n%s", test.wantKnownHosts, knownHosts)
+			}
+		})
+	}
+}
+
+func TestAcceptNewHostKeyParallel(t *testing.T) {
+	_, hostKey := startTestSshServer(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+
+	var waitGroup sync.WaitGroup
+	for range 5 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+			CheckTestError(t, acceptNewHostKey(knownHostsFile, "gitlab.company.com:22", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}, hostKey))
+		}()
+	}
+
+	waitGroup.Wait()
+
+	knownHosts, err := os.ReadFile(knownHostsFile)
+	CheckTestError(t, err)
+
+	if strings.Count(string(knownHosts), "\n") != 1 {
+		t.Errorf("Expected host to be added to known_hosts once, but got:\n%s", knownHosts)
+	}
+}
+
+func TestPinnedPolicyWithoutFile(t *testing.T) {
+	t.Setenv("SSH_HOST_KEY_POLICY", "pinned")
+	t.Setenv("SSH_HOST_FINGERPRINTS_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	TestPanic(t, "Pinned policy without fingerprints file", func() {
+		getSshHostKeyCallback(SshHostConfig{Alias: "github.com", HostName: "github.com"})
+	})
+
+	t.Setenv("SSH_HOST_KEY_POLICY", "off")
+	TestPanic(t, "Unknown policy", func() {
+		getSshHostKeyCallback(SshHostConfig{Alias: "github.com", HostName: "github.com"})
+	})
+}
+
+// Dials server the same way go-git does, with client config of the ssh auth
+func dialTestSshServer(t *testing.T, serverAddress string) error {
+	auth, _, err := getGitAuthWithError("ssh://git@"+serverAddress+"/team/repo.git", SshIdentity{})
+	CheckTestError(t, err)
+
+	clientConfig, err := auth.(*ssh.PublicKeysCallback).ClientConfig()
+	CheckTestError(t, err)
+
+	client, err := gossh.Dial("tcp", serverAddress, clientConfig)
+	if err == nil {
+		client.Close()
+	}
+
+	return err
+}
+
+func TestReadSshHostFingerprints(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string][]string
+		isError  bool
+	}{
+		{
+			"Hosts with comments and ports",
+			"# company hosts\ngithub.com SHA256:abc\n\n[gitlab.company.com]:2222 SHA256:def # rotated in 2026\ngithub.com SHA256:ghi\n",
+			map[string][]string{"github.com": {"SHA256:abc", "SHA256:ghi"}, "[gitlab.company.com]:2222": {"SHA256:def"}},
+			false,
+		},
+		{"Default port is dropped", "[github.com]:22 SHA256:abc\n", map[string][]string{"github.com": {"SHA256:abc"}}, false},
+		{"Not sha256 fingerprint", "github.com MD5:ab:cd\n", nil, true},
+		{"Missing fingerprint", "github.com\n", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fingerprintsFile := filepath.Join(t.TempDir(), "ssh_host_fingerprints")
+			CheckTestError(t, os.WriteFile(fingerprintsFile, []byte(test.content), 0o644))
+
+			fingerprints, err := readSshHostFingerprints(fingerprintsFile)
+
+			if test.isError {
+				if err == nil {
+					t.Fatalf("Expected error, but got %v", fingerprints)
+				}
+				return
+			}
+
+			CheckTestError(t, err)
+			if !reflect.DeepEqual(fingerprints, test.expected) {
+				t.Errorf("Expected %v, but got %v", test.expected, fingerprints)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	GIT_HTTP_CREDENTIALS_SEPARATOR = ","
+	GIT_HTTP_CREDENTIAL_SEPARATOR  = "="
+	GIT_HTTP_USER_SEPARATOR        = ":"
+	// Username for token-only credentials, accepted by GitLab and GitHub
+	GIT_HTTP_TOKEN_USER = "oauth2"
+	NETRC_ENV           = "NETRC"
+	NETRC_FILE_NAME     = ".netrc"
+	NETRC_DEFAULT_HOST  = "default"
+)
+
+// Basic auth credentials for one https host
+type GitHttpCredentials struct {
+	Host     string
+	User     string
+	Password string
+	Source   string
+}
+
+// Credentials come from url itself, then GIT_HTTP_CREDENTIALS, then ~/.netrc.
+// Repos without them are cloned anonymously, git credential helpers are asked only if the server requires auth
+func getGitHttpAuth(gitUrl GitURL) (transport.AuthMethod, string) {
+	credentials, found := findGitHttpCredentials(gitUrl)
+	if !found {
+		return nil, ""
+	}
+
+	AddSecret(credentials.Password)
+
+	auth := &githttp.BasicAuth{Username: credentials.User, Password: credentials.Password}
+	return auth, describeGitHttpCredentials(credentials)
+}
+
+func describeGitHttpCredentials(credentials GitHttpCredentials) string {
+	return fmt.Sprintf("basic auth user %s from %s for %s", credentials.User, credentials.Source, credentials.Host)
+}
+
+func findGitHttpCredentials(gitUrl GitURL) (GitHttpCredentials, bool) {
+	hosts := []string{gitUrl.Host}
+	if gitUrl.Port != "" {
+		hosts = []string{gitUrl.Host + ":" + gitUrl.Port, gitUrl.Host}
+	}
+
+	if gitUrl.Password != "" {
+		return GitHttpCredentials{Host: hosts[0], User: gitUrl.User, Password: gitUrl.Password, Source: "url"}, true
+	}
+
+	envCredentials, err := parseGitHttpCredentials(GetEnv(ENV_GIT_HTTP_CREDENTIALS))
+	CheckError(err, "Error when parsing "+envMap[ENV_GIT_HTTP_CREDENTIALS])
+
+	for _, host := range hosts {
+		for _, credentials := range envCredentials {
+			if strings.EqualFold(credentials.Host, host) {
+				return credentials, true
+			}
+		}
+	}
+
+	netrcPath := getNetrcPath()
+	netrcFile, err := os.ReadFile(netrcPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return GitHttpCredentials{}, false
+	}
+	CheckError(err, "Error when reading "+netrcPath)
+
+	netrcCredentials := parseNetrc(string(netrcFile), netrcPath)
+	for _, host := range append(hosts, NETRC_DEFAULT_HOST) {
+		for _, credentials := range netrcCredentials {
+			if strings.EqualFold(credentials.Host, host) {
+				credentials.Host = hosts[0]
+				return credentials, true
+			}
+		}
+	}
+
+	return GitHttpCredentials{}, false
+}
+
+// Parses "host=user:password,host2=token" credentials, ${VAR} placeholders are expanded.
+// Credentials are registered as secrets before any error, so they never get into output
+func parseGitHttpCredentials(rawCredentials string) ([]GitHttpCredentials, error) {
+	credentialsList := []GitHttpCredentials{}
+
+	for i, rawHostCredentials := range strings.Split(rawCredentials, GIT_HTTP_CREDENTIALS_SEPARATOR) {
+		rawHostCredentials = strings.TrimSpace(rawHostCredentials)
+		if rawHostCredentials == "" {
+			continue
+		}
+
+		host, secret, found := strings.Cut(rawHostCredentials, GIT_HTTP_CREDENTIAL_SEPARATOR)
+		AddSecret(secret)
+
+		secret, err := ExpandEnvPlaceholders(secret)
+		if err != nil {
+			return nil, fmt.Errorf("credentials for %s: %w", host, err)
+		}
+
+		if !found || host == "" || secret == "" {
+			// Entry itself is not printed, it may be a misplaced secret
+			return nil, fmt.Errorf("invalid credentials entry %d, expected host=user:password or host=token", i+1)
+		}
+
+		user, password, hasUser := strings.Cut(secret, GIT_HTTP_USER_SEPARATOR)
+		if !hasUser {
+			user, password = GIT_HTTP_TOKEN_USER, secret
+		}
+
+		AddSecret(password)
+		credentialsList = append(credentialsList, GitHttpCredentials{
+			Host:     strings.TrimSpace(host),
+			User:     user,
+			Password: password,
+			Source:   envMap[ENV_GIT_HTTP_CREDENTIALS],
+		})
+	}
+
+	return credentialsList, nil
+}
+
+func getNetrcPath() string {
+	if netrcPath := os.Getenv(NETRC_ENV); netrcPath != "" {
+		return netrcPath
+	}
+
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, NETRC_FILE_NAME)
+}
+
+// Reads machine/default entries with login and password, macdef bodies are skipped
+func parseNetrc(netrc string, netrcPath string) []GitHttpCredentials {
+	tokens := []string{}
+	isMacdefBody := false
+
+	for _, line := range strings.Split(netrc, "\n") {
+		if isMacdefBody {
+			isMacdefBody = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				fields = fields[:i]
+				isMacdefBody = true
+				break
+			}
+		}
+
+		tokens = append(tokens, fields...)
+	}
+
+	credentialsList := []GitHttpCredentials{}
+	var current *GitHttpCredentials
+
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] == NETRC_DEFAULT_HOST {
+			credentialsList = appendNetrcCredentials(credentialsList, current)
+			current = &GitHttpCredentials{Host: NETRC_DEFAULT_HOST, Source: netrcPath}
+			continue
+		}
+
+		if i+1 >= len(tokens) {
+			break
+		}
+
+		key, value := tokens[i], tokens[i+1]
+
+		switch key {
+		case "machine":
+			credentialsList = appendNetrcCredentials(credentialsList, current)
+			current = &GitHttpCredentials{Host: value, Source: netrcPath}
+		case "login":
+			if current != nil {
+				current.User = value
+			}
+		case "password":
+			AddSecret(value)
+			if current != nil {
+				current.Password = value
+			}
+		case "account":
+		default:
+			continue
+		}
+
+		i++
+	}
+
+	return appendNetrcCredentials(credentialsList, current)
+}
+
+func appendNetrcCredentials(credentialsList []GitHttpCredentials, credentials *GitHttpCredentials) []GitHttpCredentials {
+	if credentials == nil || credentials.Password == "" {
+		return credentialsList
+	}
+
+	if credentials.User == "" {
+		credentials.User = GIT_HTTP_TOKEN_USER
+	}
+
+	return append(credentialsList, *credentials)
+}
@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+const GIT_CA_BUNDLE_FILES_SEPARATOR = ","
+
+// Proxy and TLS settings for http(s) remotes, empty for other urls
+type gitHttpTransportOptions struct {
+	CABundle        []byte
+	ClientCert      []byte
+	ClientKey       []byte
+	InsecureSkipTLS bool
+	Proxy           transport.ProxyOptions
+}
+
+// Proxy comes from HTTPS_PROXY/HTTP_PROXY and NO_PROXY (read on every call, unlike net/http),
+// extra root CAs from GIT_CA_BUNDLE_FILES, client certificate from GIT_CLIENT_CERT_FILE and GIT_CLIENT_KEY_FILE
+func getGitHttpTransportOptions(cloneUrl string) gitHttpTransportOptions {
+	gitUrl, err := NewGitURL(cloneUrl)
+	if err != nil || !gitUrl.IsHttp() {
+		return gitHttpTransportOptions{}
+	}
+
+	options := gitHttpTransportOptions{
+		Proxy:           getGitHttpProxy(gitUrl),
+		CABundle:        readGitCABundles(),
+		InsecureSkipTLS: GetEnvBool(ENV_GIT_INSECURE_SKIP_TLS_VERIFY),
+	}
+	options.ClientCert, options.ClientKey = readGitClientCert()
+
+	if options.Proxy.URL != "" {
+		log.Debugf("Using proxy %s for %s", RedactSecrets(options.Proxy.URL), gitUrl.Host)
+	}
+
+	if options.InsecureSkipTLS {
+		log.Warn(PrepareDangerOutput(fmt.Sprintf(
+			"TLS certificate verification is OFF for %s (%s=true) - anyone on the network can read and change "+
+				"cloned code and credentials. Add your company root CA to %s instead",
+			gitUrl.Host,
+			envMap[ENV_GIT_INSECURE_SKIP_TLS_VERIFY],
+			envMap[ENV_GIT_CA_BUNDLE_FILES],
+		)))
+	}
+
+	return options
+}
+
+// Proxy password is registered as a secret, proxy url ends up in logs and errors
+func getGitHttpProxy(gitUrl GitURL) transport.ProxyOptions {
+	requestUrl := &url.URL{Scheme: gitUrl.Scheme, Host: gitUrl.Host}
+	if gitUrl.Port != "" {
+		requestUrl.Host += ":" + gitUrl.Port
+	}
+
+	proxyUrl, err := httpproxy.FromEnvironment().ProxyFunc()(requestUrl)
+	CheckError(err, "Error when reading proxy settings for "+gitUrl.Host)
+
+	if proxyUrl == nil {
+		return transport.ProxyOptions{}
+	}
+
+	password, _ := proxyUrl.User.Password()
+	AddSecret(password)
+
+	return transport.ProxyOptions{URL: proxyUrl.String()}
+}
+
+// Every file has to contain at least one certificate, go-git would silently skip broken bundles
+func readGitCABundles() []byte {
+	rawFiles := GetEnv(ENV_GIT_CA_BUNDLE_FILES)
+	if rawFiles == "" {
+		return nil
+	}
+
+	caBundle := []byte{}
+
+	for _, rawFile := range strings.Split(rawFiles, GIT_CA_BUNDLE_FILES_SEPARATOR) {
+		if strings.TrimSpace(rawFile) == "" {
+			continue
+		}
+
+		bundleFile, err := ExpandPath(strings.TrimSpace(rawFile))
+		CheckError(err, "Error when expanding "+envMap[ENV_GIT_CA_BUNDLE_FILES])
+
+		bundle, err := os.ReadFile(bundleFile)
+		CheckError(err, "Error when reading CA bundle "+bundleFile)
+
+		if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			ThrowError("No PEM certificates found in CA bundle " + bundleFile)
+		}
+
+		caBundle = append(caBundle, bundle...)
+		caBundle = append(caBundle, '\n')
+	}
+
+	return caBundle
+}
+
+func readGitClientCert() ([]byte, []byte) {
+	certFile := GetEnvPath(ENV_GIT_CLIENT_CERT_FILE)
+	keyFile := GetEnvPath(ENV_GIT_CLIENT_KEY_FILE)
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		ThrowError(envMap[ENV_GIT_CLIENT_CERT_FILE] + " and " + envMap[ENV_GIT_CLIENT_KEY_FILE] + " must be set together")
+	}
+
+	cert, err := os.ReadFile(certFile)
+	CheckError(err, "Error when reading client certificate "+certFile)
+
+	key, err := os.ReadFile(keyFile)
+	CheckError(err, "Error when reading client certificate key "+keyFile)
+
+	_, err = tls.X509KeyPair(cert, key)
+	CheckError(err, "Invalid client certificate "+certFile+" with key "+keyFile)
+
+	return cert, key
+}
+
+// Certificate errors usually mean a proxy or server with company root CA, which has to be trusted explicitly
+func wrapGitTlsError(err error) error {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	if !errors.As(err, &unknownAuthorityErr) && !errors.As(err, &certificateInvalidErr) && !errors.As(err, &hostnameErr) {
+		return err
+	}
+
+	return fmt.Errorf(
+		"%w (if the server or proxy uses a company root CA, add its PEM file to %s)",
+		err,
+		envMap[ENV_GIT_CA_BUNDLE_FILES],
+	)
+}
@@ -0,0 +1,324 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+const (
+	GIT_BUNDLE_V2_HEADER = "# v2 git bundle"
+	GIT_BUNDLE_V3_HEADER = "# v3 git bundle"
+	GIT_BUNDLE_EXTENSION = ".bundle"
+	GIT_REPO_EXTENSION   = ".git"
+)
+
+var LOCAL_PATH_PREFIXES = []string{"/", "./", "../"}
+
+func init() {
+	// Serve file:// and local path clones in-process, so a git binary is not required
+	client.InstallProtocol("file", server.NewServer(localRepoLoader{}))
+}
+
+// Unlike server.DefaultLoader, opens both bare repos and repos with a worktree
+type localRepoLoader struct{}
+
+func (localRepoLoader) Load(endpoint *transport.Endpoint) (storer.Storer, error) {
+	repo, err := git.PlainOpen(endpoint.Path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, transport.ErrRepositoryNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.Storer, nil
+}
+
+// Local path is recognized by its prefix alone, so a missing or mistyped path is reported instead of ignored
+func isLocalGitPath(value string) bool {
+	for _, prefix := range LOCAL_PATH_PREFIXES {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolves relative path against project root and checks that it points at a repo or a bundle
+func parseLocalGitPath(localPath string) (GitURL, error) {
+	resolvedPath := filepath.FromSlash(localPath)
+	if !filepath.IsAbs(resolvedPath) {
+		resolvedPath = filepath.Join(GetProjectRoot(), resolvedPath)
+	}
+
+	resolvedPath, err := filepath.Abs(resolvedPath)
+	if err != nil {
+		return GitURL{}, fmt.Errorf("cannot resolve local git path %s: %w", localPath, err)
+	}
+
+	_, err = os.Stat(resolvedPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return GitURL{}, fmt.Errorf("local git path %s doesn't exist", localPath)
+	}
+
+	if err != nil {
+		return GitURL{}, fmt.Errorf("cannot read local git path %s: %w", localPath, err)
+	}
+
+	if !isGitBundle(resolvedPath) && !isGitRepoDir(resolvedPath) {
+		return GitURL{}, fmt.Errorf("local git path %s is neither a git repo nor a git bundle", localPath)
+	}
+
+	return GitURL{Scheme: "file", Path: resolvedPath, Local: true}, nil
+}
+
+// Returns absolute filesystem path for file:// and local path git urls
+func getLocalGitPath(cleanUrl string) (string, bool) {
+	gitUrl, err := NewGitURL(cleanUrl)
+	if err != nil || gitUrl.Scheme != "file" {
+		return "", false
+	}
+
+	localPath, err := filepath.Abs(filepath.FromSlash(gitUrl.Path))
+	CheckError(err, "Error while resolving local git path "+gitUrl.Path)
+
+	return localPath, true
+}
+
+// Both bare repos and repos with a worktree
+func isGitRepoDir(localPath string) bool {
+	_, err := git.PlainOpen(localPath)
+	return err == nil
+}
+
+func isGitBundle(localPath string) bool {
+	info, err := os.Stat(localPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	bundle, err := os.Open(localPath)
+	if err != nil {
+		return false
+	}
+	defer bundle.Close()
+
+	header, err := bufio.NewReader(bundle).ReadString('\n')
+	if err != nil {
+		return false
+	}
+
+	header = strings.TrimSpace(header)
+	return header == GIT_BUNDLE_V2_HEADER || header == GIT_BUNDLE_V3_HEADER
+}
+
+// Clones git bundle file the same way git.PlainClone clones a remote
+func gitCloneBundle(
+	bundlePath string,
+	repoDirPath string,
+	reference plumbing.ReferenceName,
+) (*git.Repository, error) {
+	bundle, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	defer bundle.Close()
+
+	bundleReader := bufio.NewReader(bundle)
+	bundleRefs, err := readGitBundleRefs(bundleReader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git bundle %s: %w", bundlePath, err)
+	}
+
+	repo, err := git.PlainInit(repoDirPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = packfile.UpdateObjectStorage(repo.Storer, bundleReader)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{bundlePath},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, hash := range bundleRefs {
+		if name.IsBranch() {
+			name = plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name.Short())
+		}
+
+		if name.IsRemote() || name.IsTag() {
+			err = repo.Storer.SetReference(plumbing.NewHashReference(name, hash))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	head, err := getGitBundleHead(bundleRefs, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.Type() == plumbing.HashReference {
+		commitHash, err := repo.ResolveRevision(plumbing.Revision(head.Hash().String()))
+		if err != nil {
+			return nil, err
+		}
+
+		head = plumbing.NewHashReference(plumbing.HEAD, *commitHash)
+	} else {
+		err = setGitBundleBranch(repo, head.Target(), bundleRefs[head.Target()])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = repo.Storer.SetReference(head)
+	if err != nil {
+		return nil, err
+	}
+
+	headHash, err := repo.ResolveRevision(plumbing.Revision(plumbing.HEAD))
+	if err != nil {
+		return nil, err
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	err = workTree.Reset(&git.ResetOptions{Commit: *headHash, Mode: git.HardReset})
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// Creates local branch tracking origin, like git.PlainClone does for the checked out branch
+func setGitBundleBranch(repo *git.Repository, branch plumbing.ReferenceName, hash plumbing.Hash) error {
+	err := repo.Storer.SetReference(plumbing.NewHashReference(branch, hash))
+	if err != nil {
+		return err
+	}
+
+	return repo.CreateBranch(&config.Branch{
+		Name:   branch.Short(),
+		Remote: git.DefaultRemoteName,
+		Merge:  branch,
+	})
+}
+
+// Reads bundle header up to the packfile, returning refs listed in it
+func readGitBundleRefs(bundleReader *bufio.Reader) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	header, err := bundleReader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	header = strings.TrimSpace(header)
+	if header != GIT_BUNDLE_V2_HEADER && header != GIT_BUNDLE_V3_HEADER {
+		return nil, errors.New("unsupported bundle header " + header)
+	}
+
+	refs := map[plumbing.ReferenceName]plumbing.Hash{}
+
+	for {
+		line, err := bundleReader.ReadString('\n')
+		if err == io.EOF {
+			return nil, errors.New("bundle has no packfile")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if line == "" {
+			return refs, nil
+		}
+
+		if strings.HasPrefix(line, "@") {
+			if strings.HasPrefix(line, "@object-format=") && line != "@object-format=sha1" {
+				return nil, errors.New("unsupported bundle capability " + line)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "-") {
+			return nil, errors.New("incremental bundles with prerequisites are not supported")
+		}
+
+		hash, name, found := strings.Cut(line, " ")
+		if !found || !plumbing.IsHash(hash) {
+			return nil, errors.New("malformed bundle ref line " + line)
+		}
+
+		refs[plumbing.ReferenceName(name)] = plumbing.NewHash(hash)
+	}
+}
+
+// Returns HEAD reference for the requested branch/tag or bundle default branch
+func getGitBundleHead(
+	bundleRefs map[plumbing.ReferenceName]plumbing.Hash,
+	reference plumbing.ReferenceName,
+) (*plumbing.Reference, error) {
+	if reference != "" {
+		hash, ok := bundleRefs[reference]
+		if !ok {
+			return nil, fmt.Errorf("reference %s not found in bundle", reference)
+		}
+
+		if reference.IsTag() {
+			return plumbing.NewHashReference(plumbing.HEAD, hash), nil
+		}
+
+		return plumbing.NewSymbolicReference(plumbing.HEAD, reference), nil
+	}
+
+	headHash, hasHead := bundleRefs[plumbing.HEAD]
+
+	for _, branch := range []plumbing.ReferenceName{plumbing.Main, plumbing.Master} {
+		hash, ok := bundleRefs[branch]
+		if ok && (!hasHead || hash == headHash) {
+			return plumbing.NewSymbolicReference(plumbing.HEAD, branch), nil
+		}
+	}
+
+	for name, hash := range bundleRefs {
+		if name.IsBranch() && (!hasHead || hash == headHash) {
+			return plumbing.NewSymbolicReference(plumbing.HEAD, name), nil
+		}
+	}
+
+	if hasHead {
+		return plumbing.NewHashReference(plumbing.HEAD, headHash), nil
+	}
+
+	return nil, errors.New("bundle has no branches to check out")
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+const (
+	GIT_URL_REWRITES_SEPARATOR      = ","
+	GIT_URL_REWRITE_SEPARATOR       = "="
+	GIT_URL_REWRITES_SECTION        = "url"
+	GIT_URL_REWRITES_INSTEAD_OF_KEY = "insteadOf"
+	// Appended to rewrite target to check that it can start a git url
+	GIT_URL_REWRITE_SAMPLE_PATH = "repo.git"
+)
+
+// Replaces url prefix From with To, like git url.<To>.insteadOf = <From>
+type GitUrlRewrite struct {
+	From string
+	To   string
+}
+
+var gitUrlRewrites struct {
+	once  sync.Once
+	rules []GitUrlRewrite
+}
+
+// Applies the longest matching rewrite rule to cleanUrl (same as git insteadOf)
+func RewriteGitUrl(cleanUrl string) string {
+	gitUrlRewrites.once.Do(func() {
+		gitUrlRewrites.rules = loadGitUrlRewrites()
+	})
+
+	return rewriteGitUrl(cleanUrl, gitUrlRewrites.rules)
+}
+
+func rewriteGitUrl(cleanUrl string, rules []GitUrlRewrite) string {
+	var longestMatch *GitUrlRewrite
+
+	for i, rule := range rules {
+		if !strings.HasPrefix(cleanUrl, rule.From) {
+			continue
+		}
+
+		if longestMatch == nil || len(rule.From) > len(longestMatch.From) {
+			longestMatch = &rules[i]
+		}
+	}
+
+	if longestMatch == nil {
+		return cleanUrl
+	}
+
+	rewrittenUrl := longestMatch.To + strings.TrimPrefix(cleanUrl, longestMatch.From)
+
+	// Otherwise auth, proxy and certificate settings would silently not apply to the rewritten url
+	_, err := NewGitURL(rewrittenUrl)
+	CheckError(err, fmt.Sprintf(
+		"Git url rewrite %s=%s turns %s into %s, which is not a git url (https url on unknown host needs .git at the end)",
+		longestMatch.From,
+		longestMatch.To,
+		cleanUrl,
+		rewrittenUrl,
+	))
+
+	return rewrittenUrl
+}
+
+// Rules from GIT_URL_REWRITES come first, then from GIT_URL_REWRITES_FILE
+func loadGitUrlRewrites() []GitUrlRewrite {
+	rules := parseGitUrlRewrites(GetEnv(ENV_GIT_URL_REWRITES))
+
+	rewritesFile := GetEnvPath(ENV_GIT_URL_REWRITES_FILE)
+	if rewritesFile != "" {
+		fileRules, err := readGitUrlRewritesFile(rewritesFile)
+		CheckError(err, "Error when reading git url rewrites file "+rewritesFile)
+
+		rules = append(rules, fileRules...)
+	}
+
+	for _, rule := range rules {
+		err := validateGitUrlRewriteTarget(rule.To)
+		CheckError(err, "Invalid git url rewrite rule "+rule.From+"="+rule.To)
+	}
+
+	return rules
+}
+
+// Target is only the start of a url, so it's checked with a sample repo path appended
+func validateGitUrlRewriteTarget(target string) error {
+	if isLocalGitPath(target) {
+		return nil
+	}
+
+	_, err := NewGitURL(target + GIT_URL_REWRITE_SAMPLE_PATH)
+	if errors.Is(err, ErrNotGitUrl) {
+		return fmt.Errorf("%s can't start a git url", target)
+	}
+
+	return err
+}
+
+// Parses "from=to,from2=to2" rules
+func parseGitUrlRewrites(rawRules string) []GitUrlRewrite {
+	rules := []GitUrlRewrite{}
+
+	for _, rawRule := range strings.Split(rawRules, GIT_URL_REWRITES_SEPARATOR) {
+		rawRule = strings.TrimSpace(rawRule)
+		if rawRule == "" {
+			continue
+		}
+
+		from, to, found := strings.Cut(rawRule, GIT_URL_REWRITE_SEPARATOR)
+		if !found || from == "" || to == "" {
+			ThrowError("Invalid git url rewrite rule \"" + rawRule + "\", expected from=to")
+		}
+
+		rules = append(rules, GitUrlRewrite{From: from, To: to})
+	}
+
+	return rules
+}
+
+// Reads rules in git config format:
+//
+//	[url "https://mirror.local/github/"]
+//		insteadOf = git@github.com:
+func readGitUrlRewritesFile(rewritesFile string) ([]GitUrlRewrite, error) {
+	file, err := os.Open(rewritesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rewritesConfig := config.New()
+	err = config.NewDecoder(file).Decode(rewritesConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []GitUrlRewrite{}
+
+	for _, subsection := range rewritesConfig.Section(GIT_URL_REWRITES_SECTION).Subsections {
+		for _, from := range subsection.OptionAll(GIT_URL_REWRITES_INSTEAD_OF_KEY) {
+			rules = append(rules, GitUrlRewrite{From: from, To: subsection.Name})
+		}
+	}
+
+	return rules, nil
+}
+
+func logGitUrlRewrite(repoName string, originalUrl string, rewrittenUrl string) {
+	if originalUrl == rewrittenUrl {
+		return
+	}
+
+	repoLog := prepareGitColorOutput("repo="+repoName, REPO_COLOR)
+	originalLog := prepareGitColorOutput("url="+RedactSecrets(originalUrl), URL_COLOR)
+	rewrittenLog := prepareGitColorOutput("url="+RedactSecrets(rewrittenUrl), URL_COLOR)
+	log.Debugf("Rewriting %s %s -> %s", repoLog, originalLog, rewrittenLog)
+}
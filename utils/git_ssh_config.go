@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/kevinburke/ssh_config"
+)
+
+var SSH_SYSTEM_CONFIG_PATH = filepath.Join("/", "etc", "ssh", "ssh_config")
+
+// Connection settings for one ssh host, resolved from url and ssh_config like openssh does
+type SshHostConfig struct {
+	Alias         string
+	HostName      string
+	Port          string
+	User          string
+	IdentityFiles []string
+}
+
+var sshConfigs struct {
+	once    sync.Once
+	configs []*ssh_config.Config
+}
+
+func init() {
+	// go-git takes Port from ssh config even when url has its own port, so ssh urls are resolved by getGitDialUrl
+	// and go-git must not apply ssh config to them once more
+	ssh.DefaultSSHConfig = resolvedSshConfig{}
+}
+
+type resolvedSshConfig struct{}
+
+func (resolvedSshConfig) Get(string, string) string {
+	return ""
+}
+
+// Returns url go-git connects to. Ssh url gets host name, port and user from ssh config with url user and port winning,
+// so go-git dials the same address that host keys are looked up for
+func getGitDialUrl(cloneUrl string) string {
+	gitUrl, err := NewGitURL(cloneUrl)
+	if err != nil || !gitUrl.IsSsh() {
+		return getGitRemoteUrl(cloneUrl)
+	}
+
+	hostConfig := GetSshHostConfig(gitUrl.Host, gitUrl.User, gitUrl.Port)
+	port := hostConfig.Port
+	if port == "" {
+		port = SSH_DEFAULT_PORT
+	}
+
+	if gitUrl.ScpLike {
+		// go-git reads port of scp-like url from user@host:port:path
+		return hostConfig.User + "@" + hostConfig.HostName + ":" + port + ":" + gitUrl.Path
+	}
+
+	gitUrl.Host = hostConfig.HostName
+	gitUrl.Port = port
+	gitUrl.User = hostConfig.User
+
+	return gitUrl.String()
+}
+
+// Resolves host alias from ~/.ssh/config and /etc/ssh/ssh_config. User and port from url win over config, same as in ssh
+func GetSshHostConfig(alias string, urlUser string, urlPort string) SshHostConfig {
+	sshConfigs.once.Do(func() {
+		sshConfigs.configs = loadSshConfigs()
+	})
+
+	return resolveSshHostConfig(sshConfigs.configs, alias, urlUser, urlPort)
+}
+
+func resolveSshHostConfig(configs []*ssh_config.Config, alias string, urlUser string, urlPort string) SshHostConfig {
+	hostConfig := SshHostConfig{
+		Alias:    alias,
+		HostName: alias,
+		Port:     urlPort,
+		User:     urlUser,
+	}
+
+	if hostName := getSshConfigValue(configs, alias, "HostName"); hostName != "" {
+		hostConfig.HostName = strings.ReplaceAll(hostName, "%h", alias)
+	}
+
+	if hostConfig.Port == "" {
+		hostConfig.Port = getSshConfigValue(configs, alias, "Port")
+	}
+
+	if hostConfig.User == "" {
+		hostConfig.User = getSshConfigValue(configs, alias, "User")
+	}
+
+	if hostConfig.User == "" {
+		hostConfig.User = SSH_GIT_USER
+	}
+
+	for _, identityFile := range getSshConfigValues(configs, alias, "IdentityFile") {
+		hostConfig.IdentityFiles = append(hostConfig.IdentityFiles, expandSshConfigPath(identityFile, hostConfig))
+	}
+
+	return hostConfig
+}
+
+// User config comes first, so its values win over system ones
+func loadSshConfigs() []*ssh_config.Config {
+	configs := []*ssh_config.Config{}
+
+	homeDir, err := os.UserHomeDir()
+	configPaths := []string{SSH_SYSTEM_CONFIG_PATH}
+	if err == nil {
+		configPaths = append([]string{filepath.Join(homeDir, ".ssh", "config")}, configPaths...)
+	}
+
+	for _, configPath := range configPaths {
+		configFile, err := os.Open(configPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		CheckError(err, "Error when opening ssh config "+configPath)
+
+		// Parser doesn't support some directives (e.g. Match), such config is skipped instead of failing every clone
+		config, err := ssh_config.Decode(configFile)
+		configFile.Close()
+		if err != nil {
+			log.Warnf(PrepareWarningOutput("Ignoring ssh config %s: %s"), configPath, err.Error())
+			continue
+		}
+
+		log.Debugf("Using ssh config %s", configPath)
+		configs = append(configs, config)
+	}
+
+	return configs
+}
+
+// First value wins, as in ssh
+func getSshConfigValue(configs []*ssh_config.Config, alias string, key string) string {
+	for _, config := range configs {
+		values := getSshConfigFileValues(config, alias, key)
+		if len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// Values from all configs, for keys that can be repeated like IdentityFile
+func getSshConfigValues(configs []*ssh_config.Config, alias string, key string) []string {
+	values := []string{}
+
+	for _, config := range configs {
+		values = append(values, getSshConfigFileValues(config, alias, key)...)
+	}
+
+	return values
+}
+
+func getSshConfigFileValues(config *ssh_config.Config, alias string, key string) []string {
+	values, err := config.GetAll(alias, key)
+	if err != nil {
+		log.Warnf(PrepareWarningOutput("Error when reading %s for host %s from ssh config: %s"), key, alias, err.Error())
+		return nil
+	}
+
+	return values
+}
+
+// Supports ~ and %d (home), %h (host name), %r (remote user) and %% tokens
+func expandSshConfigPath(configPath string, hostConfig SshHostConfig) string {
+	homeDir, _ := os.UserHomeDir()
+
+	if configPath == "~" || strings.HasPrefix(configPath, "~/") {
+		configPath = homeDir + strings.TrimPrefix(configPath, "~")
+	}
+
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", homeDir,
+		"%h", hostConfig.HostName,
+		"%r", hostConfig.User,
+	).Replace(configPath)
+}
+
+// Address go-git dials, used to look up host keys
+func (hostConfig SshHostConfig) getHostWithPort() string {
+	port := hostConfig.Port
+	if port == "" {
+		port = SSH_DEFAULT_PORT
+	}
+
+	return net.JoinHostPort(hostConfig.HostName, port)
+}
+
+func (hostConfig SshHostConfig) String() string {
+	address := hostConfig.User + "@" + hostConfig.HostName
+	if hostConfig.Port != "" {
+		address += ":" + hostConfig.Port
+	}
+
+	if hostConfig.HostName == hostConfig.Alias {
+		return address
+	}
+
+	return fmt.Sprintf("%s (%s)", address, hostConfig.Alias)
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"easymodules/internal/testutil"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/kevinburke/ssh_config"
+)
+
+const TEST_SSH_CONFIG = `
+Host gitlab-work
+    HostName gitlab.company.com
+    Port 2222
+    User deploy
+    IdentityFile ~/.ssh/id_work
+    IdentityFile %d/.ssh/id_%r_%h
+
+Host *.internal
+    HostName %h.company.com
+    IdentityFile /keys/internal
+
+Host port-only
+    Port 2200
+
+Host *
+    User fallback
+`
+
+func TestResolveSshHostConfig(t *testing.T) {
+	homeDir, _ := os.UserHomeDir()
+	configs := []*ssh_config.Config{decodeTestSshConfig(t, TEST_SSH_CONFIG)}
+
+	tests := []struct {
+		name    string
+		alias   string
+		urlUser string
+		urlPort string
+		want    SshHostConfig
+	}{
+		{"Alias", "gitlab-work", "", "", SshHostConfig{
+			Alias:    "gitlab-work",
+			HostName: "gitlab.company.com",
+			Port:     "2222",
+			User:     "deploy",
+			IdentityFiles: []string{
+				filepath.Join(homeDir, ".ssh", "id_work"),
+				homeDir + "/.ssh/id_deploy_gitlab.company.com",
+			},
+		}},
+		{"Url user and port win", "gitlab-work", "git", "22", SshHostConfig{
+			Alias:    "gitlab-work",
+			HostName: "gitlab.company.com",
+			Port:     "22",
+			User:     "git",
+			IdentityFiles: []string{
+				filepath.Join(homeDir, ".ssh", "id_work"),
+				homeDir + "/.ssh/id_git_gitlab.company.com",
+			},
+		}},
+		{"Pattern with %h", "repo.internal", "git", "", SshHostConfig{
+			Alias:         "repo.internal",
+			HostName:      "repo.internal.company.com",
+			User:          "git",
+			IdentityFiles: []string{"/keys/internal"},
+		}},
+		{"Port only", "port-only", "git", "", SshHostConfig{Alias: "port-only", HostName: "port-only", Port: "2200", User: "git"}},
+		{"Unknown host", "github.com", "", "", SshHostConfig{Alias: "github.com", HostName: "github.com", User: "fallback"}},
+		{"No config", "github.com", "", "", SshHostConfig{Alias: "github.com", HostName: "github.com", User: SSH_GIT_USER}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			testConfigs := configs
+			if test.name == "No config" {
+				testConfigs = nil
+			}
+
+			hostConfig := resolveSshHostConfig(testConfigs, test.alias, test.urlUser, test.urlPort)
+
+			if hostConfig.String() != test.want.String() || !slices.Equal(hostConfig.IdentityFiles, test.want.IdentityFiles) {
+				t.Errorf("Expected %s with keys %v, but got %s with keys %v", test.want, test.want.IdentityFiles, hostConfig, hostConfig.IdentityFiles)
+			}
+		})
+	}
+}
+
+func TestLoadSshConfigs(t *testing.T) {
+	homeDir := t.TempDir()
+	t.Setenv("HOME", homeDir)
+
+	userConfigPath := filepath.Join(homeDir, ".ssh", "config")
+	CheckTestError(t, os.MkdirAll(filepath.Dir(userConfigPath), 0o700))
+	CheckTestError(t, os.WriteFile(userConfigPath, []byte(TEST_SSH_CONFIG), 0o600))
+
+	systemConfigPath := filepath.Join(t.TempDir(), "ssh_config")
+	CheckTestError(t, os.WriteFile(systemConfigPath, []byte("Match user deploy\n    Port 2222\n"), 0o600))
+
+	previousSystemConfigPath := SSH_SYSTEM_CONFIG_PATH
+	SSH_SYSTEM_CONFIG_PATH = systemConfigPath
+	defer func() { SSH_SYSTEM_CONFIG_PATH = previousSystemConfigPath }()
+
+	configs := loadSshConfigs()
+	if len(configs) != 1 {
+		t.Fatalf("Expected user config to be loaded and unsupported system config to be skipped, but got %d configs", len(configs))
+	}
+
+	if hostConfig := resolveSshHostConfig(configs, "gitlab-work", "", ""); hostConfig.HostName != "gitlab.company.com" {
+		t.Errorf("Expected alias from user config to be resolved, but got %s", hostConfig)
+	}
+}
+
+func TestGetGitDialUrl(t *testing.T) {
+	useTestSshConfig(t, TEST_SSH_CONFIG)
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"ssh://git@gitlab-work/team/repo.git", "ssh://git@gitlab.company.com:2222/team/repo.git"},
+		{"ssh://git@gitlab-work:2200/team/repo.git", "ssh://git@gitlab.company.com:2200/team/repo.git"},
+		{"ssh://gitlab-work/team/repo.git", "ssh://deploy@gitlab.company.com:2222/team/repo.git"},
+		{"git@gitlab-work:team/repo.git", "git@gitlab.company.com:2222:team/repo.git"},
+		{"ssh://git@port-only/team/repo.git", "ssh://git@port-only:2200/team/repo.git"},
+		{"git@github.com:SergeyDarn/test-module-js.git", "git@github.com:22:SergeyDarn/test-module-js.git"},
+		{"https://github.com/SergeyDarn/test-module-js.git", "https://github.com/SergeyDarn/test-module-js.git"},
+	}
+
+	for _, test := range tests {
+		if dialUrl := getGitDialUrl(test.url); dialUrl != test.want {
+			t.Errorf("Expected %s to be dialed as %s, but got %s", test.url, test.want, dialUrl)
+		}
+	}
+}
+
+func TestGitCloneSshConfigPort(t *testing.T) {
+	reposDir := t.TempDir()
+	testutil.CreateTestRepo(t, filepath.Join(reposDir, "team", "repo"))
+
+	keyPath := filepath.Join(t.TempDir(), "id_test")
+	publicKey := createTestSshKey(t, keyPath, "")
+	sshAddress, _ := startTestGitSshServer(t, reposDir, publicKey)
+	_, sshPort, err := net.SplitHostPort(sshAddress)
+	CheckTestError(t, err)
+
+	// Nothing listens on port 1, so the clone only works if the right port is dialed
+	useTestSshConfig(t, `
+Host wrong-port
+    HostName 127.0.0.1
+    Port 1
+
+Host right-port
+    HostName 127.0.0.1
+    Port `+sshPort+`
+`)
+
+	t.Setenv(SSH_AUTH_SOCK, "")
+	t.Setenv("SSH_AUTH_METHOD", "key")
+	t.Setenv("SSH_KEY_PATH", keyPath)
+	t.Setenv("SSH_HOST_KEYS", "")
+	t.Setenv("SSH_HOST_KEY_POLICY", "accept-new")
+	t.Setenv("SSH_PROJECT_KNOWN_HOSTS_FILE", filepath.Join(t.TempDir(), "known_hosts"))
+	t.Setenv(SSH_KNOWN_HOSTS_ENV, filepath.Join(t.TempDir(), "missing_known_hosts"))
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"Url port wins over config", "ssh://git@wrong-port:" + sshPort + "/team/repo/.git"},
+		{"Config port", "ssh://git@right-port/team/repo/.git"},
+		{"Config port for scp-like url", "git@right-port:/team/repo/.git"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repoDir := filepath.Join(t.TempDir(), "repo")
+			GitClone("repo", test.url, repoDir)
+
+			repo, err := git.PlainOpen(repoDir)
+			CheckTestError(t, err)
+
+			origin, err := repo.Remote(git.DefaultRemoteName)
+			CheckTestError(t, err)
+
+			if origin.Config().URLs[0] != test.url {
+				t.Errorf("Expected origin %s, but got %s", test.url, origin.Config().URLs[0])
+			}
+		})
+	}
+}
+
+func decodeTestSshConfig(t *testing.T, content string) *ssh_config.Config {
+	config, err := ssh_config.Decode(strings.NewReader(content))
+	CheckTestError(t, err)
+
+	return config
+}
+
+// Replaces ssh configs of this machine with given content for the test
+func useTestSshConfig(t *testing.T, content string) {
+	sshConfigs.once.Do(func() {})
+
+	previousConfigs := sshConfigs.configs
+	sshConfigs.configs = []*ssh_config.Config{decodeTestSshConfig(t, content)}
+
+	t.Cleanup(func() { sshConfigs.configs = previousConfigs })
+}
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+const SSH_PASSPHRASE_PROMPT_ATTEMPTS = 3
+
+type sshKeyPassphrase struct {
+	passphrase string
+	err        error
+}
+
+// Passphrases of encrypted keys without a password in settings, by key path.
+// Parallel clones wait for the first one to ask, so the user is asked once per key
+var (
+	sshKeyPassphrases      = map[string]sshKeyPassphrase{}
+	sshKeyPassphrasesMutex sync.Mutex
+)
+
+// Replaced in tests, there is no terminal there
+var (
+	isSshPassphraseTerminal = func() bool {
+		return term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	readSshPassphrase = func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		return string(passphrase), err
+	}
+)
+
+// Encrypted key without password in settings was not loaded, because asking for the passphrase is not allowed
+var errSshKeyPassphraseNotAsked = errors.New("ssh key is encrypted and its passphrase is not asked for")
+
+// Parses private key, asking for passphrase if the key is encrypted and password is not set.
+// If canAskPassphrase is false, such key fails with errSshKeyPassphraseNotAsked instead
+func parseSshPrivateKey(identity SshIdentity, canAskPassphrase bool) (gossh.Signer, error) {
+	pemBytes, err := os.ReadFile(identity.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := gossh.ParsePrivateKey(pemBytes)
+
+	var passphraseMissingError *gossh.PassphraseMissingError
+	if !errors.As(err, &passphraseMissingError) {
+		return signer, err
+	}
+
+	if identity.KeyPassword != "" {
+		return gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(identity.KeyPassword))
+	}
+
+	if !canAskPassphrase {
+		return nil, fmt.Errorf("%w: %s", errSshKeyPassphraseNotAsked, identity.KeyPath)
+	}
+
+	passphrase, err := getSshKeyPassphrase(identity.KeyPath, pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+}
+
+// SSH_KEY_PASSWORD_CMD goes first, then terminal prompt. Result is remembered for the key, even if it's an error
+func getSshKeyPassphrase(keyPath string, pemBytes []byte) (string, error) {
+	sshKeyPassphrasesMutex.Lock()
+	defer sshKeyPassphrasesMutex.Unlock()
+
+	if result, ok := sshKeyPassphrases[keyPath]; ok {
+		return result.passphrase, result.err
+	}
+
+	var result sshKeyPassphrase
+
+	if passphraseCommand := GetEnv(ENV_SSH_KEY_PASSWORD_CMD); passphraseCommand != "" {
+		result.passphrase, result.err = runSshPassphraseCommand(passphraseCommand, keyPath)
+	} else if isSshPassphraseTerminal() {
+		result.passphrase, result.err = promptSshKeyPassphrase(keyPath, pemBytes)
+	} else {
+		result.err = fmt.Errorf(
+			"ssh key %s is encrypted, but there is no password for it and no terminal to ask for one. "+
+				"Set %s (or sshKeyPassword of the module, %s for the host), set %s to a command printing it, "+
+				"or add the key to ssh-agent with ssh-add",
+			keyPath,
+			envMap[ENV_SSH_KEY_PASSWORD],
+			envMap[ENV_SSH_HOST_KEY_PASSWORDS],
+			envMap[ENV_SSH_KEY_PASSWORD_CMD],
+		)
+	}
+
+	if result.err == nil {
+		AddSecret(result.passphrase)
+	}
+
+	sshKeyPassphrases[keyPath] = result
+	return result.passphrase, result.err
+}
+
+// Runs command with shell, key path is passed as $1. Output without trailing newline is the passphrase
+func runSshPassphraseCommand(passphraseCommand string, keyPath string) (string, error) {
+	var stdout bytes.Buffer
+
+	command := exec.Command("sh", "-c", passphraseCommand, "sh", keyPath)
+	command.Stdin = os.Stdin
+	command.Stdout = &stdout
+	command.Stderr = os.Stderr
+
+	err := command.Run()
+	if err != nil {
+		return "", fmt.Errorf("%s failed for ssh key %s: %w", envMap[ENV_SSH_KEY_PASSWORD_CMD], keyPath, err)
+	}
+
+	passphrase := strings.TrimRight(stdout.String(), "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("%s printed empty passphrase for ssh key %s", envMap[ENV_SSH_KEY_PASSWORD_CMD], keyPath)
+	}
+
+	return passphrase, nil
+}
+
+// Asks like ssh does, checking each answer against the key
+func promptSshKeyPassphrase(keyPath string, pemBytes []byte) (string, error) {
+	for attempt := 1; attempt <= SSH_PASSPHRASE_PROMPT_ATTEMPTS; attempt++ {
+		passphrase, err := readSshPassphrase("Enter passphrase for ssh key " + keyPath + ": ")
+		if err != nil {
+			return "", fmt.Errorf("couldn't read passphrase for ssh key %s: %w", keyPath, err)
+		}
+
+		_, err = gossh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+		if err == nil {
+			return passphrase, nil
+		}
+
+		fmt.Fprintln(os.Stderr, PrepareWarningOutput("Wrong passphrase for ssh key "+keyPath))
+	}
+
+	return "", fmt.Errorf("wrong passphrase for ssh key %s, gave up after %d attempts", keyPath, SSH_PASSPHRASE_PROMPT_ATTEMPTS)
+}
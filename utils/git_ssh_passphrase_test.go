@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestParseEncryptedSshKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	createTestSshKey(t, keyPath, "key-passphrase")
+
+	tests := []struct {
+		name              string
+		password          string
+		passwordCommand   string
+		isTerminal        bool
+		terminalAnswers   []string
+		wantError         string
+		wantPromptsNumber int
+	}{
+		{"Password from settings", "key-passphrase", "", false, nil, "", 0},
+		{"Wrong password from settings", "wrong", "", true, nil, "decryption password incorrect", 0},
+		{"No password without terminal", "", "", false, nil, "no terminal to ask", 0},
+		{"Password command", "", `test "$1" = "` + keyPath + `" && echo key-passphrase`, false, nil, "", 0},
+		{"Password command goes before terminal", "", "printf key-passphrase", true, []string{"wrong"}, "", 0},
+		{"Failing password command", "", "exit 3", false, nil, "SSH_KEY_PASSWORD_CMD failed", 0},
+		{"Empty password command output", "", "echo", false, nil, "empty passphrase", 0},
+		{"Terminal prompt", "", "", true, []string{"key-passphrase"}, "", 1},
+		{"Terminal prompt after wrong answer", "", "", true, []string{"wrong", "key-passphrase"}, "", 2},
+		{"Terminal prompt gives up", "", "", true, []string{"wrong", "wrong", "wrong", "key-passphrase"}, "gave up after 3 attempts", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SSH_KEY_PASSWORD_CMD", test.passwordCommand)
+			prompts := useTestSshPassphraseTerminal(t, test.isTerminal, test.terminalAnswers)
+
+			_, err := parseSshPrivateKey(SshIdentity{KeyPath: keyPath, KeyPassword: test.password}, true)
+
+			if test.wantError == "" {
+				CheckTestError(t, err)
+			} else if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("Expected error containing %q, but got %v", test.wantError, err)
+			}
+
+			if int(prompts.Load()) != test.wantPromptsNumber {
+				t.Errorf("Expected %d prompts, but got %d", test.wantPromptsNumber, prompts.Load())
+			}
+		})
+	}
+}
+
+func TestSshKeyPassphrasePromptedOnce(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	createTestSshKey(t, keyPath, "key-passphrase")
+	otherKeyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	createTestSshKey(t, otherKeyPath, "key-passphrase")
+
+	t.Setenv("SSH_KEY_PASSWORD_CMD", "")
+	prompts := useTestSshPassphraseTerminal(t, true, []string{"key-passphrase", "key-passphrase"})
+
+	var waitGroup sync.WaitGroup
+	for index := range 10 {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			identity := SshIdentity{KeyPath: keyPath}
+			if index%2 == 1 {
+				identity.KeyPath = otherKeyPath
+			}
+
+			_, err := getSshKeySigners([]SshIdentity{identity}, true)
+			CheckTestError(t, err)
+		}()
+	}
+
+	waitGroup.Wait()
+
+	if prompts.Load() != 2 {
+		t.Errorf("Expected passphrase to be asked once per key, but it was asked %d times", prompts.Load())
+	}
+
+	if RedactSecrets("passphrase is key-passphrase") != "passphrase is "+SECRET_PLACEHOLDER {
+		t.Errorf("Expected entered passphrase to be redacted")
+	}
+}
+
+func TestSshKeyPassphraseWithAgent(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	keyFilePublicKey := createTestSshKey(t, keyPath, "key-passphrase")
+	agentPublicKey, _ := startTestSshAgent(t)
+	agentSocket := os.Getenv(SSH_AUTH_SOCK)
+	useTestSshConfig(t, "")
+
+	t.Setenv("SSH_AUTH_METHOD", "auto")
+	t.Setenv("SSH_HOST_KEYS", "")
+	t.Setenv("SSH_KEY_PASSWORD", "")
+	t.Setenv("SSH_KEY_PASSWORD_CMD", "")
+	t.Setenv("SSH_KEY_PATH", keyPath)
+
+	tests := []struct {
+		name              string
+		agentSocket       string
+		moduleIdentity    SshIdentity
+		want              []gossh.PublicKey
+		wantPromptsNumber int
+	}{
+		{"Agent has keys", agentSocket, SshIdentity{}, []gossh.PublicKey{agentPublicKey}, 0},
+		{"No agent", "", SshIdentity{}, []gossh.PublicKey{keyFilePublicKey}, 1},
+		{"Explicit module key", agentSocket, SshIdentity{KeyPath: keyPath}, []gossh.PublicKey{keyFilePublicKey, agentPublicKey}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(SSH_AUTH_SOCK, test.agentSocket)
+			prompts := useTestSshPassphraseTerminal(t, true, []string{"key-passphrase"})
+
+			auth, _, err := getGitAuthWithError(TEST_SSH_URL, test.moduleIdentity)
+			CheckTestError(t, err)
+			signers, err := auth.(*ssh.PublicKeysCallback).Callback()
+			CheckTestError(t, err)
+
+			if len(signers) != len(test.want) {
+				t.Fatalf("Expected %d keys, but got %d", len(test.want), len(signers))
+			}
+
+			for i, publicKey := range test.want {
+				if string(signers[i].PublicKey().Marshal()) != string(publicKey.Marshal()) {
+					t.Errorf("Expected key %d to be %s", i, gossh.FingerprintSHA256(publicKey))
+				}
+			}
+
+			if int(prompts.Load()) != test.wantPromptsNumber {
+				t.Errorf("Expected %d prompts, but got %d", test.wantPromptsNumber, prompts.Load())
+			}
+		})
+	}
+}
+
+// Fakes terminal with given answers, returns number of prompts. Remembered passphrases are reset
+func useTestSshPassphraseTerminal(t *testing.T, isTerminal bool, answers []string) *atomic.Int32 {
+	t.Helper()
+
+	originalIsTerminal, originalRead := isSshPassphraseTerminal, readSshPassphrase
+	sshKeyPassphrases = map[string]sshKeyPassphrase{}
+
+	t.Cleanup(func() {
+		isSshPassphraseTerminal, readSshPassphrase = originalIsTerminal, originalRead
+		sshKeyPassphrases = map[string]sshKeyPassphrase{}
+	})
+
+	var prompts atomic.Int32
+
+	isSshPassphraseTerminal = func() bool { return isTerminal }
+	readSshPassphrase = func(prompt string) (string, error) {
+		promptNumber := int(prompts.Add(1))
+		if promptNumber > len(answers) {
+			return "", errors.New("no more answers")
+		}
+
+		return answers[promptNumber-1], nil
+	}
+
+	return &prompts
+}
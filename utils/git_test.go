@@ -1,12 +1,18 @@
 package utils
 
 import (
+	"easymodules/internal/testutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 func TestIsGitUrl(t *testing.T) {
@@ -14,12 +20,21 @@ func TestIsGitUrl(t *testing.T) {
 		url  string
 		want bool
 	}{
-		{"git", true},
+		{"git", false},
 		{"htts://google.com/", false},
 		{"randomString", false},
 		{"cool-js-module", false},
+		{"digit-utils", false},
+		{"^1.0.0", false},
+		{"~2.3.4 || >=3.0.0", false},
+		{"file:../local-module", false},
+		{"https://registry.npmjs.org/digit-utils/-/digit-utils-1.0.0.tgz", false},
 		{"https://github.com/SergeyDarn/scrape-search-ai.git", true},
 		{"git@github.com:SergeyDarn/scrape-search-ai.git", true},
+		{"https://code.company.com/team/repo.git#1.0", true},
+		{"ssh://git@code.company.com:2222/team/repo.git", true},
+		{"git+https://code.company.com/team/repo", true},
+		{"file:///srv/git/repo.git", true},
 	}
 
 	for _, test := range tests {
@@ -78,6 +93,14 @@ func TestParseGitUrl(t *testing.T) {
 			cleanUrl:   "git@github.com:SergeyDarn/scrape-search-ai.git",
 			commitHash: "b7620f64a115b85eca08504cb9b364e594c9f8df",
 		}},
+		{"Short Commit Hash", "git@github.com:SergeyDarn/scrape-search-ai.git#b7620f6", want{
+			cleanUrl:   "git@github.com:SergeyDarn/scrape-search-ai.git",
+			commitHash: "b7620f6",
+		}},
+		{"Too Short Commit Hash is a branch", "git@github.com:SergeyDarn/scrape-search-ai.git#b7620f", want{
+			cleanUrl: "git@github.com:SergeyDarn/scrape-search-ai.git",
+			branch:   plumbing.NewBranchReferenceName("b7620f"),
+		}},
 
 		{"Tag 1.4.0", "git@github.com:SergeyDarn/scrape-search-ai.git#1.4.0", want{
 			cleanUrl: "git@github.com:SergeyDarn/scrape-search-ai.git",
@@ -163,22 +186,64 @@ type gitCloneWant struct {
 
 func TestGitClone(t *testing.T) {
 	testDir := "TEST_GIT_CLONE_DIR"
+
+	InitEnv()
+	os.RemoveAll(testDir)
+
+	fixtureDir := filepath.Join(testDir, "fixtures")
+	testRepo := testutil.CreateTestRepo(t, filepath.Join(fixtureDir, "test-module-js"))
+	projectRoot, err := filepath.Abs(GetProjectRoot())
+	CheckTestError(t, err)
+	relativeRepoPath, err := filepath.Rel(projectRoot, testRepo.Dir)
+	CheckTestError(t, err)
+	relativeRepoPath = "./" + filepath.ToSlash(relativeRepoPath)
+	bundlePath := filepath.Join(testRepo.Dir, "..", "test-module-js.bundle")
+	testutil.CreateTestBundle(t, testRepo.Repo, bundlePath)
+
+	firstCommit := testRepo.FirstCommit.String()
+
 	tests := []gitCloneTest{
-		{"Master/Main", "main", "https://github.com/SergeyDarn/test-module-js.git", gitCloneWant{
+		{"Master/Main", "main", testRepo.Url, gitCloneWant{
 			head: plumbing.Main.Short(),
 		}},
-		{"SSH", "ssh", "git@github.com:SergeyDarn/test-module-js.git", gitCloneWant{
+		{"Absolute Path", "absolute_path", testRepo.Dir, gitCloneWant{
 			head: plumbing.Main.Short(),
 		}},
-		{"Branch", "branch", "https://github.com/SergeyDarn/test-module-js.git#dev", gitCloneWant{
-			head: "dev",
+		{"Relative Path", "relative_path", relativeRepoPath, gitCloneWant{
+			head: plumbing.Main.Short(),
+		}},
+		{"Branch", "branch", testRepo.Url + "#" + testutil.TEST_REPO_BRANCH, gitCloneWant{
+			head: testutil.TEST_REPO_BRANCH,
+		}},
+		{"Commit Hash", "commit", testRepo.Url + "#" + firstCommit, gitCloneWant{
+			head:   firstCommit,
+			commit: true,
 		}},
-		{"Commit Hash", "commit", "https://github.com/SergeyDarn/test-module-js.git#5d62004178df760fd8978ef166e9ab14d23b06d1", gitCloneWant{
-			head:   "5d62004178df760fd8978ef166e9ab14d23b06d1",
+		{"Short Commit Hash", "short_commit", testRepo.Url + "#" + firstCommit[:7], gitCloneWant{
+			head:   firstCommit,
 			commit: true,
 		}},
-		{"Tag", "tag", "https://github.com/SergeyDarn/test-module-js.git#1.0.0", gitCloneWant{
-			head: "1.0.0",
+		{"Tag", "tag", testRepo.Url + "#" + testutil.TEST_REPO_TAG, gitCloneWant{
+			head: testutil.TEST_REPO_TAG,
+			tag:  true,
+		}},
+		{"Annotated Tag", "annotated_tag", testRepo.Url + "#" + testutil.TEST_REPO_ANNOTATED_TAG, gitCloneWant{
+			head: testutil.TEST_REPO_ANNOTATED_TAG,
+			tag:  true,
+		}},
+
+		{"Bundle", "bundle", bundlePath, gitCloneWant{
+			head: plumbing.Main.Short(),
+		}},
+		{"Bundle Branch", "bundle_branch", bundlePath + "#" + testutil.TEST_REPO_BRANCH, gitCloneWant{
+			head: testutil.TEST_REPO_BRANCH,
+		}},
+		{"Bundle Commit Hash", "bundle_commit", bundlePath + "#" + firstCommit[:7], gitCloneWant{
+			head:   firstCommit,
+			commit: true,
+		}},
+		{"Bundle Annotated Tag", "bundle_tag", bundlePath + "#" + testutil.TEST_REPO_ANNOTATED_TAG, gitCloneWant{
+			head: testutil.TEST_REPO_ANNOTATED_TAG,
 			tag:  true,
 		}},
 
@@ -186,22 +251,22 @@ func TestGitClone(t *testing.T) {
 		{"Invalid Reference", "invalid_reference", "git@#github.#com:S#ergeyD#arn/test-module-js.git#", gitCloneWant{
 			error: true,
 		}},
-		{"Not Existing Tag", "wrong_tag", "https://github.com/SergeyDarn/test-module-js.git#1.0.0_doesnt_exist", gitCloneWant{
+		{"Not Existing Tag", "wrong_tag", testRepo.Url + "#1.0.0_doesnt_exist", gitCloneWant{
 			tag:   true,
 			error: true,
 		}},
-		{"Not Existing Branch", "wrong_branch", "https://github.com/SergeyDarn/test-module-js.git#doesnt_exist", gitCloneWant{
+		{"Not Existing Branch", "wrong_branch", testRepo.Url + "#doesnt_exist", gitCloneWant{
 			error: true,
 		}},
-		{"Not Existing Commit", "wrong_commit", "https://github.com/SergeyDarn/test-module-js.git#06a3e504bee6033d42690d01100edede077c7fe5", gitCloneWant{
+		{"Not Existing Commit", "wrong_commit", testRepo.Url + "#06a3e504bee6033d42690d01100edede077c7fe5", gitCloneWant{
 			commit: true,
 			error:  true,
 		}},
+		{"Not Existing Bundle Branch", "wrong_bundle_branch", bundlePath + "#doesnt_exist", gitCloneWant{
+			error: true,
+		}},
 	}
 
-	InitEnv()
-	os.RemoveAll(testDir)
-
 	t.Run("GitClone Group", func(t *testing.T) {
 		for _, test := range tests {
 			t.Run(test.name, func(t *testing.T) {
@@ -239,3 +304,152 @@ func testGitClone(t *testing.T, test gitCloneTest, testDir string) {
 		t.Errorf("Expected HEAD to be %s, but got %s", test.want.head, headName)
 	}
 }
+
+func TestGetHeadTag(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  func(repo *git.Repository, head plumbing.Hash) error
+		want string
+	}{
+		{"Lightweight tag", func(repo *git.Repository, head plumbing.Hash) error {
+			_, err := repo.CreateTag("1.0.0", head, nil)
+			return err
+		}, "1.0.0"},
+		{"Annotated tag", func(repo *git.Repository, head plumbing.Hash) error {
+			_, err := repo.CreateTag("1.1.0", head, &git.CreateTagOptions{
+				Tagger:  &object.Signature{Name: "Tester", Email: "tester@example.com", When: time.Now()},
+				Message: "Release 1.1.0",
+			})
+			return err
+		}, "1.1.0"},
+		{"Tag pointing at a blob", func(repo *git.Repository, head plumbing.Hash) error {
+			blobHash := writeTestBlob(t, repo)
+			_, err := repo.CreateTag("1.2.0", blobHash, nil)
+			return err
+		}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := testutil.InitTestRepo(t, t.TempDir())
+			head := testutil.CommitTestFile(t, repo, "index.js", "module.exports = {};\n")
+
+			err := test.tag(repo, head)
+			CheckTestError(t, err)
+
+			headTag := GetHeadTag(repo)
+			tagName := ""
+			if headTag != nil {
+				tagName = headTag.Name().Short()
+			}
+
+			if tagName != test.want {
+				t.Errorf("Expected head tag to be %q, but got %q", test.want, tagName)
+			}
+		})
+	}
+}
+
+func writeTestBlob(t *testing.T, repo *git.Repository) plumbing.Hash {
+	t.Helper()
+
+	blob := repo.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+
+	writer, err := blob.Writer()
+	CheckTestError(t, err)
+
+	_, err = writer.Write([]byte("not a commit"))
+	CheckTestError(t, err)
+	CheckTestError(t, writer.Close())
+
+	hash, err := repo.Storer.SetEncodedObject(blob)
+	CheckTestError(t, err)
+
+	return hash
+}
+
+func TestGitCloneMirrors(t *testing.T) {
+	testRepo := testutil.CreateTestRepo(t, t.TempDir())
+	unreachableUrl := "https://127.0.0.1:1/test-module-js.git"
+
+	t.Run("Fallback on network error", func(t *testing.T) {
+		repoDir := filepath.Join(t.TempDir(), "mirror")
+		GitCloneWithOptions("mirror", unreachableUrl+"#"+testutil.TEST_REPO_BRANCH, repoDir, GitCloneOptions{
+			Mirrors: []string{"https://127.0.0.2:1/test-module-js.git", testRepo.Url},
+		})
+
+		repo, err := git.PlainOpen(repoDir)
+		CheckTestError(t, err)
+
+		headName := GetHeadShortName(repo, false, false)
+		if headName != testutil.TEST_REPO_BRANCH {
+			t.Errorf("Expected HEAD to be %s, but got %s", testutil.TEST_REPO_BRANCH, headName)
+		}
+
+		origin, err := repo.Remote(git.DefaultRemoteName)
+		CheckTestError(t, err)
+
+		if origin.Config().URLs[0] != unreachableUrl {
+			t.Errorf("Expected origin to be canonical url %s, but got %s", unreachableUrl, origin.Config().URLs[0])
+		}
+	})
+
+	t.Run("No fallback on reference error", func(t *testing.T) {
+		reposDir := t.TempDir()
+		mirrorRepo := testutil.CreateTestRepo(t, filepath.Join(reposDir, "test-module-js"))
+		mirrorWorkTree, err := mirrorRepo.Repo.Worktree()
+		CheckTestError(t, err)
+
+		err = mirrorWorkTree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("mirror_only"), Create: true})
+		CheckTestError(t, err)
+
+		var mirrorRequests atomic.Int32
+		mirrorHandler := newTestGitHttpHandler(t, reposDir, "", "")
+		mirrorServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			mirrorRequests.Add(1)
+			mirrorHandler.ServeHTTP(writer, request)
+		}))
+		t.Cleanup(mirrorServer.Close)
+
+		repoDir := filepath.Join(t.TempDir(), "wrong_branch")
+
+		TestPanic(t, "No fallback on reference error", func() {
+			GitCloneWithOptions("wrong_branch", testRepo.Url+"#mirror_only", repoDir, GitCloneOptions{
+				Mirrors: []string{mirrorServer.URL + "/test-module-js/.git"},
+			})
+		})
+
+		if mirrorRequests.Load() != 0 {
+			t.Errorf("Expected mirror not to be contacted after reference error, but got %d requests", mirrorRequests.Load())
+		}
+	})
+
+	t.Run("Rewritten url keeps canonical origin", func(t *testing.T) {
+		canonicalUrl := "https://code.company.com/team/test-module-js.git"
+		useTestGitUrlRewrites(t, []GitUrlRewrite{{From: canonicalUrl, To: testRepo.Url}})
+
+		repoDir := filepath.Join(t.TempDir(), "rewritten")
+		GitClone("rewritten", canonicalUrl+"#"+testutil.TEST_REPO_BRANCH, repoDir)
+
+		repo, err := git.PlainOpen(repoDir)
+		CheckTestError(t, err)
+
+		origin, err := repo.Remote(git.DefaultRemoteName)
+		CheckTestError(t, err)
+
+		if origin.Config().URLs[0] != canonicalUrl {
+			t.Errorf("Expected origin to be url from config %s, but got %s", canonicalUrl, origin.Config().URLs[0])
+		}
+	})
+
+	t.Run("Mirror with reference", func(t *testing.T) {
+		repoDir := filepath.Join(t.TempDir(), "mirror_reference")
+
+		TestPanic(t, "Mirror with reference", func() {
+			GitCloneWithOptions("mirror_reference", testRepo.Url, repoDir, GitCloneOptions{
+				Mirrors: []string{testRepo.Url + "#" + testutil.TEST_REPO_BRANCH},
+			})
+		})
+	})
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	GIT_PLUS_PREFIX = "git+"
+	SCP_URL_REGEXP  = `^([^@/:\s]+)@([^@/:\s]+):([^\s]+)$`
+)
+
+var ErrNotGitUrl = errors.New("not a git url")
+
+// Hosts that serve git repos over https even without .git suffix (same as npm)
+var KNOWN_GIT_HOSTS = []string{
+	"github.com",
+	"gitlab.com",
+	"bitbucket.org",
+	"gist.github.com",
+}
+
+var GIT_URL_SCHEMES = []string{"ssh", "git", "http", "https", "file"}
+
+var TARBALL_EXTENSIONS = []string{".tgz", ".tar.gz", ".tar", ".zip"}
+
+// Structured git dependency url, e.g. git@github.com:user/repo.git#1.0.0
+type GitURL struct {
+	Scheme   string
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Path     string
+	Ref      string
+	ScpLike  bool
+	Local    bool
+}
+
+// Parses dependency url into GitURL.
+// Returns ErrNotGitUrl for values that are not git urls (semver ranges, tarballs, npm file: paths etc.)
+// and a regular error for git urls that are malformed.
+// Local paths (./repo.git, ../repo, /srv/repo.bundle) are resolved against project root
+// and must point at an existing repo or bundle
+func NewGitURL(rawUrl string) (GitURL, error) {
+	baseUrl, ref, hasRef := strings.Cut(rawUrl, GIT_URL_SEPARATOR)
+	if hasRef && (ref == "" || strings.Contains(ref, GIT_URL_SEPARATOR)) {
+		return GitURL{}, fmt.Errorf("cannot properly parse url %s", rawUrl)
+	}
+
+	gitUrl, err := parseGitBaseUrl(baseUrl)
+	if err != nil {
+		return GitURL{}, err
+	}
+
+	gitUrl.Ref = ref
+
+	return gitUrl, nil
+}
+
+func IsGitUrl(url string) bool {
+	_, err := NewGitURL(url)
+	return !errors.Is(err, ErrNotGitUrl)
+}
+
+// Returns url without reference, in the form go-git expects
+func (gitUrl GitURL) String() string {
+	if gitUrl.Local {
+		return gitUrl.Path
+	}
+
+	if gitUrl.ScpLike {
+		return gitUrl.User + "@" + gitUrl.Host + ":" + gitUrl.Path
+	}
+
+	host := gitUrl.Host
+	if gitUrl.Port != "" {
+		host += ":" + gitUrl.Port
+	}
+
+	cleanUrl := url.URL{
+		Scheme: gitUrl.Scheme,
+		Host:   host,
+		Path:   gitUrl.Path,
+	}
+
+	if gitUrl.Password != "" {
+		cleanUrl.User = url.UserPassword(gitUrl.User, gitUrl.Password)
+	} else if gitUrl.User != "" {
+		cleanUrl.User = url.User(gitUrl.User)
+	}
+
+	return cleanUrl.String()
+}
+
+func (gitUrl GitURL) IsSsh() bool {
+	return gitUrl.Scheme == "ssh"
+}
+
+func (gitUrl GitURL) IsHttp() bool {
+	return gitUrl.Scheme == "http" || gitUrl.Scheme == "https"
+}
+
+func parseGitBaseUrl(baseUrl string) (GitURL, error) {
+	scpRegexp, _ := regexp.Compile(SCP_URL_REGEXP)
+
+	if isLocalGitPath(baseUrl) {
+		return parseLocalGitPath(baseUrl)
+	}
+
+	if !strings.Contains(baseUrl, "://") {
+		match := scpRegexp.FindStringSubmatch(baseUrl)
+		if match == nil {
+			return GitURL{}, ErrNotGitUrl
+		}
+
+		return GitURL{
+			Scheme:  "ssh",
+			User:    match[1],
+			Host:    match[2],
+			Path:    match[3],
+			ScpLike: true,
+		}, nil
+	}
+
+	parsedUrl, err := url.Parse(baseUrl)
+	if err != nil {
+		return GitURL{}, ErrNotGitUrl
+	}
+
+	hasGitPlusPrefix := strings.HasPrefix(parsedUrl.Scheme, GIT_PLUS_PREFIX)
+	scheme := strings.TrimPrefix(parsedUrl.Scheme, GIT_PLUS_PREFIX)
+
+	if !slices.Contains(GIT_URL_SCHEMES, scheme) {
+		return GitURL{}, ErrNotGitUrl
+	}
+
+	gitUrl := GitURL{
+		Scheme: scheme,
+		Host:   parsedUrl.Hostname(),
+		Port:   parsedUrl.Port(),
+		User:   parsedUrl.User.Username(),
+		Path:   parsedUrl.Path,
+	}
+	gitUrl.Password, _ = parsedUrl.User.Password()
+
+	if isTarballPath(gitUrl.Path) {
+		return GitURL{}, ErrNotGitUrl
+	}
+
+	if scheme == "file" {
+		if gitUrl.Path == "" {
+			return GitURL{}, ErrNotGitUrl
+		}
+
+		return gitUrl, nil
+	}
+
+	if gitUrl.Host == "" || strings.Trim(gitUrl.Path, "/") == "" {
+		return GitURL{}, ErrNotGitUrl
+	}
+
+	isHttpGitUrl := hasGitPlusPrefix ||
+		strings.HasSuffix(gitUrl.Path, ".git") ||
+		slices.Contains(KNOWN_GIT_HOSTS, strings.ToLower(gitUrl.Host))
+
+	if gitUrl.IsHttp() && !isHttpGitUrl {
+		return GitURL{}, ErrNotGitUrl
+	}
+
+	return gitUrl, nil
+}
+
+func isTarballPath(urlPath string) bool {
+	fileName := strings.ToLower(path.Base(urlPath))
+
+	for _, extension := range TARBALL_EXTENSIONS {
+		if strings.HasSuffix(fileName, extension) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewGitURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawUrl    string
+		want      GitURL
+		cleanUrl  string
+		notGitUrl bool
+		error     bool
+	}{
+		{"Scp-like", "git@github.com:SergeyDarn/test-module-js.git#dev", GitURL{
+			Scheme: "ssh", User: "git", Host: "github.com", Path: "SergeyDarn/test-module-js.git", Ref: "dev", ScpLike: true,
+		}, "git@github.com:SergeyDarn/test-module-js.git", false, false},
+		{"Ssh with port", "ssh://git@code.company.com:2222/team/repo.git#1.0.0", GitURL{
+			Scheme: "ssh", User: "git", Host: "code.company.com", Port: "2222", Path: "/team/repo.git", Ref: "1.0.0",
+		}, "ssh://git@code.company.com:2222/team/repo.git", false, false},
+		{"Https", "https://github.com/SergeyDarn/scrape-search-ai#dev", GitURL{
+			Scheme: "https", Host: "github.com", Path: "/SergeyDarn/scrape-search-ai", Ref: "dev",
+		}, "https://github.com/SergeyDarn/scrape-search-ai", false, false},
+		{"Self-hosted https", "https://code.company.com/team/repo.git#1.0", GitURL{
+			Scheme: "https", Host: "code.company.com", Path: "/team/repo.git", Ref: "1.0",
+		}, "https://code.company.com/team/repo.git", false, false},
+		{"Git plus https", "git+https://user@code.company.com/team/repo", GitURL{
+			Scheme: "https", User: "user", Host: "code.company.com", Path: "/team/repo",
+		}, "https://user@code.company.com/team/repo", false, false},
+		{"File", "file:///srv/git/repo.git#3a7a190", GitURL{
+			Scheme: "file", Path: "/srv/git/repo.git", Ref: "3a7a190",
+		}, "file:///srv/git/repo.git", false, false},
+
+		{"Semver range", "^1.0.0", GitURL{}, "", true, false},
+		{"Package name with git substring", "digit-utils", GitURL{}, "", true, false},
+		{"Tarball", "https://code.company.com/team/repo.git/archive/1.0.tar.gz", GitURL{}, "", true, false},
+		{"Self-hosted https without .git", "https://code.company.com/team/repo", GitURL{}, "", true, false},
+		{"Npm file path", "file:../local-module", GitURL{}, "", true, false},
+		{"Npm alias", "npm:other-package@1.0.0", GitURL{}, "", true, false},
+
+		{"Empty reference", "git@github.com:SergeyDarn/test-module-js.git#", GitURL{}, "", false, true},
+		{"Multiple references", "https://github.com/SergeyDarn/test-module-js.git#1.0#2.0", GitURL{}, "", false, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gitUrl, err := NewGitURL(test.rawUrl)
+
+			if test.notGitUrl {
+				if !errors.Is(err, ErrNotGitUrl) {
+					t.Fatalf("Expected %s not to be a git url, but got %+v (err: %v)", test.rawUrl, gitUrl, err)
+				}
+				return
+			}
+
+			if test.error {
+				if err == nil || errors.Is(err, ErrNotGitUrl) {
+					t.Fatalf("Expected %s to be a malformed git url, but got err: %v", test.rawUrl, err)
+				}
+				return
+			}
+
+			CheckTestError(t, err)
+
+			if gitUrl != test.want {
+				t.Errorf("Expected %+v, but got %+v", test.want, gitUrl)
+			}
+
+			if gitUrl.String() != test.cleanUrl {
+				t.Errorf("Expected clean url %s, but got %s", test.cleanUrl, gitUrl.String())
+			}
+		})
+	}
+}
+
+func TestRewriteGitUrl(t *testing.T) {
+	rewritesFile := filepath.Join(t.TempDir(), "rewrites.gitconfig")
+	rewritesConfig := `[url "https://mirror.local/github/"]
+	insteadOf = git@github.com:
+	insteadOf = https://github.com/
+[url "https://mirror.local/team/"]
+	insteadOf = git@github.com:team/
+`
+	err := os.WriteFile(rewritesFile, []byte(rewritesConfig), 0o644)
+	CheckTestError(t, err)
+
+	t.Setenv("GIT_URL_REWRITES", "ssh://git@code.company.com/=https://code.company.com/")
+	t.Setenv("GIT_URL_REWRITES_FILE", rewritesFile)
+	rules := loadGitUrlRewrites()
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"git@github.com:SergeyDarn/test-module-js.git", "https://mirror.local/github/SergeyDarn/test-module-js.git"},
+		{"https://github.com/SergeyDarn/test-module-js.git", "https://mirror.local/github/SergeyDarn/test-module-js.git"},
+		{"git@github.com:team/ui-kit.git", "https://mirror.local/team/ui-kit.git"},
+		{"ssh://git@code.company.com/team/repo.git", "https://code.company.com/team/repo.git"},
+		{"git@gitlab.com:team/repo.git", "git@gitlab.com:team/repo.git"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			rewrittenUrl := rewriteGitUrl(test.url, rules)
+
+			if rewrittenUrl != test.want {
+				t.Errorf("Expected %s, but got %s", test.want, rewrittenUrl)
+			}
+		})
+	}
+
+	TestPanic(t, "Invalid rewrite rule", func() { parseGitUrlRewrites("git@github.com:") })
+	TestPanic(t, "Rewrite to not a git url", func() {
+		rewriteGitUrl("https://github.com/SergeyDarn/scrape-search-ai", rules)
+	})
+
+	for _, target := range []string{"mirror.local/github/", "ftp://mirror.local/", "https://"} {
+		t.Setenv("GIT_URL_REWRITES", "git@github.com:="+target)
+		t.Setenv("GIT_URL_REWRITES_FILE", "")
+		TestPanic(t, "Invalid rewrite target "+target, func() { loadGitUrlRewrites() })
+	}
+}
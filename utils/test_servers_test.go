@@ -0,0 +1,365 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Writes new ed25519 private key in OpenSSH format, encrypted if passphrase is not empty
+func createTestSshKey(t *testing.T, keyPath string, passphrase string) gossh.PublicKey {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	CheckTestError(t, err)
+
+	var keyBlock *pem.Block
+	if passphrase == "" {
+		keyBlock, err = gossh.MarshalPrivateKey(privateKey, "")
+	} else {
+		keyBlock, err = gossh.MarshalPrivateKeyWithPassphrase(privateKey, "", []byte(passphrase))
+	}
+	CheckTestError(t, err)
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(keyBlock), 0o600)
+	CheckTestError(t, err)
+
+	sshPublicKey, err := gossh.NewPublicKey(publicKey)
+	CheckTestError(t, err)
+
+	return sshPublicKey
+}
+
+// Serves in-memory ssh-agent with one new key and points SSH_AUTH_SOCK to it.
+// Returns the key and function counting connections to the agent
+func startTestSshAgent(t *testing.T) (gossh.PublicKey, func() int32) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	CheckTestError(t, err)
+
+	keyring := agent.NewKeyring()
+	err = keyring.Add(agent.AddedKey{PrivateKey: privateKey})
+	CheckTestError(t, err)
+
+	// Unix socket paths are limited to ~100 chars, so t.TempDir() can be too long
+	socketDir, err := os.MkdirTemp("", "agent")
+	CheckTestError(t, err)
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	listener, err := net.Listen("unix", filepath.Join(socketDir, "agent.sock"))
+	CheckTestError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	var connections atomic.Int32
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			connections.Add(1)
+			go agent.ServeAgent(keyring, connection)
+		}
+	}()
+
+	t.Setenv(SSH_AUTH_SOCK, listener.Addr().String())
+
+	sshPublicKey, err := gossh.NewPublicKey(publicKey)
+	CheckTestError(t, err)
+
+	return sshPublicKey, connections.Load
+}
+
+// Serves repos from reposDir over smart http with git http-backend, requiring basic auth if user is not empty.
+// Handler can be started with httptest.NewTLSServer as well
+func newTestGitHttpHandler(t *testing.T, reposDir string, user string, password string) http.Handler {
+	execPath, err := exec.Command("git", "--exec-path").Output()
+	if err != nil {
+		t.Skip("git is not installed: " + err.Error())
+	}
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(execPath)), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + reposDir, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestUser, requestPassword, _ := request.BasicAuth()
+		if user != "" && (requestUser != user || requestPassword != password) {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		backend.ServeHTTP(writer, request)
+	})
+}
+
+func startTestGitHttpServer(t *testing.T, reposDir string, user string, password string) *httptest.Server {
+	server := httptest.NewServer(newTestGitHttpHandler(t, reposDir, user, password))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// Points git to a stub credential helper that answers with given credentials and logs store/erase calls.
+// Returns path of the log file
+func useTestGitCredentialHelper(t *testing.T, user string, password string) string {
+	helperDir := t.TempDir()
+	logPath := filepath.Join(helperDir, "helper.log")
+	helperPath := filepath.Join(helperDir, "helper.sh")
+
+	helper := fmt.Sprintf(`#!/bin/sh
+input=$(cat)
+echo "$1 $(echo "$input" | grep -E '^(host|username|password)=' | tr '\n' ' ')" >> %q
+if [ "$1" = get ]; then
+	printf 'username=%%s\npassword=%%s\n' %q %q
+fi
+`, logPath, user, password)
+
+	err := os.WriteFile(helperPath, []byte(helper), 0o755)
+	CheckTestError(t, err)
+
+	gitConfigPath := filepath.Join(helperDir, "gitconfig")
+	err = os.WriteFile(gitConfigPath, []byte(fmt.Sprintf("[credential]\n\thelper = %q\n", "!"+helperPath)), 0o644)
+	CheckTestError(t, err)
+
+	t.Setenv("GIT_CONFIG_GLOBAL", gitConfigPath)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CREDENTIAL_HELPERS", "")
+
+	return logPath
+}
+
+// Serves ssh handshakes with a new ed25519 host key, accepting clients with any of authorizedKeys.
+// Connections are closed right after authentication, it's enough to check auth and host keys
+func startTestSshServer(t *testing.T, authorizedKeys ...gossh.PublicKey) (string, gossh.PublicKey) {
+	return serveTestSsh(t, "", authorizedKeys)
+}
+
+// Same as startTestSshServer, but also serves git-upload-pack for repos from reposDir, so repos can be cloned over ssh
+func startTestGitSshServer(t *testing.T, reposDir string, authorizedKeys ...gossh.PublicKey) (string, gossh.PublicKey) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed: " + err.Error())
+	}
+
+	return serveTestSsh(t, reposDir, authorizedKeys)
+}
+
+func serveTestSsh(t *testing.T, reposDir string, authorizedKeys []gossh.PublicKey) (string, gossh.PublicKey) {
+	_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	CheckTestError(t, err)
+
+	hostSigner, err := gossh.NewSignerFromKey(hostPrivateKey)
+	CheckTestError(t, err)
+
+	serverConfig := &gossh.ServerConfig{
+		PublicKeyCallback: func(_ gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			for _, authorizedKey := range authorizedKeys {
+				if string(authorizedKey.Marshal()) == string(key.Marshal()) {
+					return nil, nil
+				}
+			}
+
+			return nil, fmt.Errorf("unknown key %s", gossh.FingerprintSHA256(key))
+		},
+	}
+	serverConfig.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	CheckTestError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer connection.Close()
+
+				_, channels, requests, err := gossh.NewServerConn(connection, serverConfig)
+				if err != nil || reposDir == "" {
+					return
+				}
+
+				go gossh.DiscardRequests(requests)
+				serveTestGitSshChannels(channels, reposDir)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveTestGitSshChannels(channels <-chan gossh.NewChannel, reposDir string) {
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(gossh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go serveTestGitSshSession(channel, requests, reposDir)
+	}
+}
+
+// Runs "git-upload-pack '/path'" exec requests with git upload-pack, other requests are refused
+func serveTestGitSshSession(channel gossh.Channel, requests <-chan *gossh.Request, reposDir string) {
+	defer channel.Close()
+
+	for request := range requests {
+		var execRequest struct{ Command string }
+		if request.Type != "exec" || gossh.Unmarshal(request.Payload, &execRequest) != nil {
+			request.Reply(false, nil)
+			continue
+		}
+
+		service, repoPath, _ := strings.Cut(execRequest.Command, " ")
+		if service != "git-upload-pack" {
+			request.Reply(false, nil)
+			return
+		}
+		request.Reply(true, nil)
+
+		command := exec.Command("git", "upload-pack", filepath.Join(reposDir, strings.Trim(repoPath, "'")))
+		command.Stdout = channel
+		command.Stderr = channel.Stderr()
+
+		// exec would wait for stdin copying, which only ends when client closes the channel
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(stdin, channel)
+			stdin.Close()
+		}()
+
+		exitStatus := uint32(0)
+		if command.Run() != nil {
+			exitStatus = 1
+		}
+
+		channel.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{exitStatus}))
+		return
+	}
+}
+
+// Writes PEM of certificate, e.g. of httptest TLS server, so it can be used as a CA bundle
+func writeTestCertificate(t *testing.T, certPath string, cert *x509.Certificate) {
+	err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644)
+	CheckTestError(t, err)
+}
+
+// Creates self-signed client certificate with its key, returns pool to verify it on the server
+func createTestClientCert(t *testing.T, certPath string, keyPath string) *x509.CertPool {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	CheckTestError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "easy-modules test client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, privateKey)
+	CheckTestError(t, err)
+
+	cert, err := x509.ParseCertificate(certBytes)
+	CheckTestError(t, err)
+	writeTestCertificate(t, certPath, cert)
+
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	CheckTestError(t, err)
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}), 0o600)
+	CheckTestError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return pool
+}
+
+// Serves CONNECT tunnels to targetAddress whatever host is asked for, requiring basic proxy auth if user is not empty.
+// Returns server and function listing hosts clients asked to connect to
+func startTestConnectProxy(t *testing.T, targetAddress string, user string, password string) (*httptest.Server, func() []string) {
+	var requestedHostsMutex sync.Mutex
+	requestedHosts := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+		if user != "" && request.Header.Get("Proxy-Authorization") != wantAuth {
+			writer.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		if request.Method != http.MethodConnect {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		requestedHostsMutex.Lock()
+		requestedHosts = append(requestedHosts, request.Host)
+		requestedHostsMutex.Unlock()
+
+		targetConnection, err := net.Dial("tcp", targetAddress)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		writer.WriteHeader(http.StatusOK)
+		clientConnection, _, err := http.NewResponseController(writer).Hijack()
+		if err != nil {
+			targetConnection.Close()
+			return
+		}
+
+		go func() {
+			io.Copy(targetConnection, clientConnection)
+			targetConnection.Close()
+		}()
+		io.Copy(clientConnection, targetConnection)
+		clientConnection.Close()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		requestedHostsMutex.Lock()
+		defer requestedHostsMutex.Unlock()
+
+		return slices.Clone(requestedHosts)
+	}
+}
@@ -1,10 +1,21 @@
 package utils
 
 import (
+	"io"
 	"os"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	SECRET_PLACEHOLDER = "***"
+	// Password part of user:password@ in urls
+	URL_PASSWORD_REGEXP = `(://[^/\s:@]*:)[^/\s@]+@`
 )
 
 const (
@@ -21,18 +32,60 @@ func CheckError(err error, readableErrMessage string) {
 	ThrowError(readableErrMessage + ": " + err.Error())
 }
 
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
 func ThrowError(err string) {
-	panic(PrepareDangerOutput(err))
+	panic(PrepareDangerOutput(RedactSecrets(err)))
+}
+
+// Registers value that must never be printed (tokens, passwords etc.)
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	if slices.Contains(secrets.values, secret) {
+		return
+	}
+
+	secrets.values = append(secrets.values, secret)
 }
 
-func GetPathRootDir(path string) string {
-	splitPath := strings.Split(path, string(os.PathSeparator))
+// Replaces registered secrets and passwords in urls
+func RedactSecrets(output string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
 
-	if len(splitPath) == 0 {
-		return ""
+	for _, secret := range secrets.values {
+		output = strings.ReplaceAll(output, secret, SECRET_PLACEHOLDER)
 	}
 
-	return splitPath[0]
+	urlPasswordRegexp, _ := regexp.Compile(URL_PASSWORD_REGEXP)
+	return urlPasswordRegexp.ReplaceAllString(output, "${1}"+SECRET_PLACEHOLDER+"@")
+}
+
+// Sends every log line through RedactSecrets, keeping colors detected for stderr
+func SetRedactingLogOutput() {
+	colorProfile := lipgloss.NewRenderer(os.Stderr).ColorProfile()
+
+	log.SetOutput(RedactingWriter{Writer: os.Stderr})
+	log.SetColorProfile(colorProfile)
+}
+
+// Writer that redacts secrets, so every log line is safe to print
+type RedactingWriter struct {
+	Writer io.Writer
+}
+
+func (redactingWriter RedactingWriter) Write(output []byte) (int, error) {
+	_, err := redactingWriter.Writer.Write([]byte(RedactSecrets(string(output))))
+	return len(output), err
 }
 
 func PrepareSuccessOutput(output string) string {